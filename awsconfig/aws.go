@@ -39,6 +39,12 @@ type Output struct {
 // If roleArn is provided, it assumes the role.
 // Otherwise it works with default credentials.
 func AwsConfig(opt Options) (Output, error) {
+	return AwsConfigContext(context.Background(), opt)
+}
+
+// AwsConfigContext is like AwsConfig, but the context bounds the calls
+// for loading configuration and for querying caller identity.
+func AwsConfigContext(ctx context.Context, opt Options) (Output, error) {
 	const me = "AwsConfig"
 
 	var out Output
@@ -60,7 +66,7 @@ func AwsConfig(opt Options) (Output, error) {
 		return retry.AddWithMaxBackoffDelay(r, opt.RetryMaxBackoffDelay)
 	})
 
-	cfg, errConfig := config.LoadDefaultConfig(context.TODO(),
+	cfg, errConfig := config.LoadDefaultConfig(ctx,
 		optionsFunc, config.WithRegion(opt.Region))
 	if errConfig != nil {
 		opt.Printf("%s: load config: %v", me, errConfig)
@@ -74,7 +80,7 @@ func AwsConfig(opt Options) (Output, error) {
 		opt.Printf("%s: AssumeRole: arn: %s", me, opt.RoleArn)
 		clientSts := sts.NewFromConfig(cfg)
 		cfg2, errConfig2 := config.LoadDefaultConfig(
-			context.TODO(), optionsFunc, config.WithRegion(opt.Region),
+			ctx, optionsFunc, config.WithRegion(opt.Region),
 			config.WithCredentialsProvider(aws.NewCredentialsCache(
 				stscreds.NewAssumeRoleProvider(
 					clientSts,
@@ -107,7 +113,7 @@ func AwsConfig(opt Options) (Output, error) {
 			}
 		})
 		input := sts.GetCallerIdentityInput{}
-		respSts, errSts := clientSts.GetCallerIdentity(context.TODO(), &input)
+		respSts, errSts := clientSts.GetCallerIdentity(ctx, &input)
 		if errSts != nil {
 			opt.Printf("%s: GetCallerIdentity: error: %v", me, errSts)
 		} else {
@@ -2,6 +2,7 @@
 package envconfig
 
 import (
+	"context"
 	"log"
 	"os"
 	"strconv"
@@ -21,6 +22,7 @@ type Env struct {
 // Options defines client options.
 type Options struct {
 	DisableQueryStore bool
+	Interpolate       bool // resolve secret references embedded as ${reference}, escape literal ${ as $${
 	Secret            *secret.Secret
 	Printf            boilerplate.FuncPrintf
 }
@@ -57,7 +59,7 @@ func NewSimple(sessionName string) *Env {
 	return env
 }
 
-func (e *Env) getEnv(name string) string {
+func (e *Env) getEnv(ctx context.Context, name string) string {
 	value := os.Getenv(name)
 
 	if value == "" {
@@ -68,14 +70,68 @@ func (e *Env) getEnv(name string) string {
 		return value
 	}
 
-	return e.options.Secret.Retrieve(value)
+	if e.options.Interpolate && strings.Contains(value, "${") {
+		return e.interpolate(ctx, value)
+	}
+
+	return e.options.Secret.RetrieveContext(ctx, value)
+}
+
+// interpolate resolves secret references embedded in value:
+//
+//	postgres://${aws-secretsmanager::db:user}:${aws-secretsmanager::db:password}@db:5432/app
+//
+// Both references above share a single query through the secret cache.
+// A literal ${ is written as $${. References must not contain '}'.
+func (e *Env) interpolate(ctx context.Context, value string) string {
+	const me = "Env.interpolate"
+
+	var sb strings.Builder
+
+	for {
+		begin := strings.Index(value, "${")
+		if begin < 0 {
+			sb.WriteString(value)
+			break
+		}
+
+		if begin > 0 && value[begin-1] == '$' {
+			// escaped: $${ -> ${
+			sb.WriteString(value[:begin-1])
+			sb.WriteString("${")
+			value = value[begin+2:]
+			continue
+		}
+
+		end := strings.IndexByte(value[begin+2:], '}')
+		if end < 0 {
+			e.options.Printf("%s: unterminated reference, keeping literal value: %s",
+				me, value[begin:])
+			sb.WriteString(value)
+			break
+		}
+
+		name := value[begin+2 : begin+2+end]
+
+		sb.WriteString(value[:begin])
+		sb.WriteString(e.options.Secret.RetrieveContext(ctx, name))
+
+		value = value[begin+2+end+1:]
+	}
+
+	return sb.String()
 }
 
 // String extracts string from env var.
 // It returns the provided defaultValue if the env var is empty.
 // The string returned is also recorded in logs.
 func (e *Env) String(name string, defaultValue string) string {
-	str := e.getEnv(name)
+	return e.StringContext(context.Background(), name, defaultValue)
+}
+
+// StringContext is like String, but the context bounds the secret query.
+func (e *Env) StringContext(ctx context.Context, name string, defaultValue string) string {
+	str := e.getEnv(ctx, name)
 	if str != "" {
 		e.options.Printf("%s=[%s] using %s=%s default=%s", name, str, name, str, defaultValue)
 		return str
@@ -88,7 +144,12 @@ func (e *Env) String(name string, defaultValue string) string {
 // It returns the provided defaultValue if the env var is empty.
 // The value returned is also recorded in logs.
 func (e *Env) Bool(name string, defaultValue bool) bool {
-	str := e.getEnv(name)
+	return e.BoolContext(context.Background(), name, defaultValue)
+}
+
+// BoolContext is like Bool, but the context bounds the secret query.
+func (e *Env) BoolContext(ctx context.Context, name string, defaultValue bool) bool {
+	str := e.getEnv(ctx, name)
 	if str != "" {
 		value, errConv := strconv.ParseBool(str)
 		if errConv == nil {
@@ -105,7 +166,12 @@ func (e *Env) Bool(name string, defaultValue bool) bool {
 // It returns the provided defaultValue if the env var is empty.
 // The value returned is also recorded in logs.
 func (e *Env) Duration(name string, defaultValue time.Duration) time.Duration {
-	str := e.getEnv(name)
+	return e.DurationContext(context.Background(), name, defaultValue)
+}
+
+// DurationContext is like Duration, but the context bounds the secret query.
+func (e *Env) DurationContext(ctx context.Context, name string, defaultValue time.Duration) time.Duration {
+	str := e.getEnv(ctx, name)
 	if str != "" {
 		value, errConv := time.ParseDuration(str)
 		if errConv == nil {
@@ -122,7 +188,12 @@ func (e *Env) Duration(name string, defaultValue time.Duration) time.Duration {
 // It returns the provided defaultValue if the env var is empty.
 // The value returned is also recorded in logs.
 func (e *Env) Int(name string, defaultValue int) int {
-	str := e.getEnv(name)
+	return e.IntContext(context.Background(), name, defaultValue)
+}
+
+// IntContext is like Int, but the context bounds the secret query.
+func (e *Env) IntContext(ctx context.Context, name string, defaultValue int) int {
+	str := e.getEnv(ctx, name)
 	if str != "" {
 		value, errConv := strconv.Atoi(str)
 		if errConv == nil {
@@ -139,7 +210,12 @@ func (e *Env) Int(name string, defaultValue int) int {
 // It returns the provided defaultValue if the env var is empty.
 // The value returned is also recorded in logs.
 func (e *Env) Uint64(name string, defaultValue uint64) uint64 {
-	str := e.getEnv(name)
+	return e.Uint64Context(context.Background(), name, defaultValue)
+}
+
+// Uint64Context is like Uint64, but the context bounds the secret query.
+func (e *Env) Uint64Context(ctx context.Context, name string, defaultValue uint64) uint64 {
+	str := e.getEnv(ctx, name)
 	if str != "" {
 		value, errConv := strconv.ParseUint(str, 10, 64)
 		if errConv == nil {
@@ -156,7 +232,12 @@ func (e *Env) Uint64(name string, defaultValue uint64) uint64 {
 // It returns the provided defaultValue if the env var is empty.
 // The value returned is also recorded in logs.
 func (e *Env) Int64(name string, defaultValue int64) int64 {
-	str := e.getEnv(name)
+	return e.Int64Context(context.Background(), name, defaultValue)
+}
+
+// Int64Context is like Int64, but the context bounds the secret query.
+func (e *Env) Int64Context(ctx context.Context, name string, defaultValue int64) int64 {
+	str := e.getEnv(ctx, name)
 	if str != "" {
 		value, errConv := strconv.ParseInt(str, 10, 64)
 		if errConv == nil {
@@ -173,7 +254,12 @@ func (e *Env) Int64(name string, defaultValue int64) int64 {
 // It returns the provided defaultValue if the env var is empty.
 // The value returned is also recorded in logs.
 func (e *Env) Float64(name string, defaultValue float64) float64 {
-	str := e.getEnv(name)
+	return e.Float64Context(context.Background(), name, defaultValue)
+}
+
+// Float64Context is like Float64, but the context bounds the secret query.
+func (e *Env) Float64Context(ctx context.Context, name string, defaultValue float64) float64 {
+	str := e.getEnv(ctx, name)
 	if str != "" {
 		value, errConv := strconv.ParseFloat(str, 64)
 		if errConv == nil {
@@ -190,7 +276,12 @@ func (e *Env) Float64(name string, defaultValue float64) float64 {
 // It returns the provided defaultValue if the env var is empty.
 // The value returned is also recorded in logs.
 func (e *Env) Float64Slice(name string, defaultValue []float64) []float64 {
-	str := e.getEnv(name)
+	return e.Float64SliceContext(context.Background(), name, defaultValue)
+}
+
+// Float64SliceContext is like Float64Slice, but the context bounds the secret query.
+func (e *Env) Float64SliceContext(ctx context.Context, name string, defaultValue []float64) []float64 {
+	str := e.getEnv(ctx, name)
 	if str == "" {
 		e.options.Printf("%s=[%s] using %s=%v default=%v", name, str, name, defaultValue, defaultValue)
 		return defaultValue
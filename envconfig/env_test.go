@@ -1,9 +1,12 @@
 package envconfig
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"testing"
+
+	"github.com/udhos/boilerplate/secret"
 )
 
 // Float64SliceEmpty keeps linter happy.
@@ -101,3 +104,62 @@ func TestUint64(t *testing.T) {
 		t.Errorf("expected=0 got=%v", zero)
 	}
 }
+
+type interpolateTestCase struct {
+	name     string
+	value    string
+	expected string
+}
+
+var interpolateTestTable = []interpolateTestCase{
+	{"no reference", "postgres://db:5432/app", "postgres://db:5432/app"},
+	{"two references", "postgres://${test-store::db:user}:${test-store::db:password}@db:5432/app", "postgres://admin:pwd@db:5432/app"},
+	{"literal inside", "user=${literal}", "user=literal"},
+	{"escaped", "a=$${test-store::db:user} b=${test-store::db:user}", "a=${test-store::db:user} b=admin"},
+	{"unterminated", "a=${test-store::db:user", "a=${test-store::db:user"},
+	{"whole", "${test-store::db:password}", "pwd"},
+}
+
+func TestInterpolate(t *testing.T) {
+
+	var queries int
+
+	s := secret.New(secret.Options{
+		AwsConfigSource: &secret.AwsConfigSource{},
+		Backends: map[string]secret.Backend{
+			"test-store": secret.BackendFunc(func(_ context.Context, _ secret.Query) (string, error) {
+				queries++
+				return `{"user":"admin","password":"pwd"}`, nil
+			}),
+		},
+	})
+
+	env := New(Options{Secret: s, Interpolate: true})
+
+	for _, data := range interpolateTestTable {
+		t.Run(data.name, func(t *testing.T) {
+			t.Setenv("VALUE", data.value)
+			if v := env.String("VALUE", ""); v != data.expected {
+				t.Errorf("expected=%s got=%s", data.expected, v)
+			}
+		})
+	}
+
+	if queries != 1 {
+		t.Errorf("expected single query, got %d", queries)
+	}
+}
+
+func TestInterpolateDisabled(t *testing.T) {
+
+	const value = "${test-store::db:user}"
+
+	s := secret.New(secret.Options{AwsConfigSource: &secret.AwsConfigSource{}})
+
+	env := New(Options{Secret: s})
+
+	t.Setenv("VALUE", value)
+	if v := env.String("VALUE", ""); v != value {
+		t.Errorf("expected=%s got=%s", value, v)
+	}
+}
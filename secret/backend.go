@@ -0,0 +1,113 @@
+package secret
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+
+	"github.com/udhos/boilerplate/boilerplate"
+)
+
+// Backend retrieves secrets from a store.
+//
+// Custom stores are plugged in with Secret.RegisterBackend or
+// Options.Backends. The Secret parses the name, then calls Query
+// with the store-specific part. Caching, JSON field extraction
+// and CrashOnQueryError are handled by the Secret, exactly as
+// for the built-in stores.
+//
+// The context carries the caller deadline, further bounded by
+// Options.QueryTimeoutSeconds.
+type Backend interface {
+	Query(ctx context.Context, q Query) (string, error)
+}
+
+// BackendFunc is an adapter to allow the use of ordinary functions as backends.
+type BackendFunc func(ctx context.Context, q Query) (string, error)
+
+// Query calls f(ctx, q).
+func (f BackendFunc) Query(ctx context.Context, q Query) (string, error) {
+	return f(ctx, q)
+}
+
+// cacheKeyer is implemented by backends returning different raw values
+// for references that differ only by field, which are then cached apart.
+type cacheKeyer interface {
+	cacheKey(ref Reference) string
+}
+
+// backendCacheKey returns the cache key for the reference queried with
+// the backend.
+func backendCacheKey(b Backend, ref Reference) string {
+	if k, isKeyer := b.(cacheKeyer); isKeyer {
+		return k.cacheKey(ref)
+	}
+	return ref.cacheKey()
+}
+
+// Query holds parameters for a single backend query.
+//
+// For CONFIG_VAR=my-store:us-east-1:db:uri a backend registered
+// for prefix "my-store" is queried with Region="us-east-1" and Name="db".
+//
+// For CONFIG_VAR=secret+my-store://us-east-1/db?field=uri&opt=1 the backend
+// gets the same Region and Name, and Reference.Options carries opt=1.
+type Query struct {
+	Debug     bool
+	Printf    boilerplate.FuncPrintf
+	Region    string    // region from name, possibly empty
+	Name      string    // store-specific secret name
+	Reference Reference // parsed reference, with URI options
+
+	awsConfig AwsConfigSolver
+	secret    *Secret
+}
+
+// AwsConfig provides aws configuration for the query region.
+func (q Query) AwsConfig(ctx context.Context) (aws.Config, error) {
+	return q.awsConfig.get(ctx, q.Region)
+}
+
+// EndpointURL provides optional custom endpoint for aws services.
+func (q Query) EndpointURL() string {
+	return q.awsConfig.endpointURL()
+}
+
+// Retrieve resolves a nested secret reference, like a credential
+// required to query the store. Literal values are returned as is.
+func (q Query) Retrieve(ctx context.Context, name string) (string, error) {
+	if q.secret == nil {
+		return name, nil
+	}
+	return q.secret.RetrieveWithErrorContext(ctx, name)
+}
+
+// BatchBackend is a Backend able to fetch multiple secrets with a single call.
+//
+// Secret.RetrieveMany calls QueryBatch once for all queries sharing
+// backend and region. QueryBatch returns one result per query, in the
+// same order as queries.
+type BatchBackend interface {
+	Backend
+	QueryBatch(ctx context.Context, queries []Query) []BatchResult
+}
+
+// BatchResult holds the result of one query from a batch.
+type BatchResult struct {
+	Value string
+	Err   error
+}
+
+// batchBackend adapts a pair of functions into a BatchBackend.
+type batchBackend struct {
+	query BackendFunc
+	batch func(ctx context.Context, queries []Query) []BatchResult
+}
+
+func (b batchBackend) Query(ctx context.Context, q Query) (string, error) {
+	return b.query(ctx, q)
+}
+
+func (b batchBackend) QueryBatch(ctx context.Context, queries []Query) []BatchResult {
+	return b.batch(ctx, queries)
+}
@@ -0,0 +1,216 @@
+package secret
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRegisterBackend(t *testing.T) {
+
+	var queries []Query
+
+	store := BackendFunc(func(_ context.Context, q Query) (string, error) {
+		queries = append(queries, q)
+		return `{"uri":"mongodb://store"}`, nil
+	})
+
+	secretOptions := Options{
+		AwsConfigSource: &AwsConfigSource{},
+		Backends:        map[string]Backend{"my-store": store},
+	}
+	secret := New(secretOptions)
+
+	value, err := secret.RetrieveWithError("my-store:us-east-1:db:uri")
+	if err != nil {
+		t.Fatalf("retrieve: %v", err)
+	}
+
+	const expected = "mongodb://store"
+	if value != expected {
+		t.Errorf("secret error: expected=%s got=%s", expected, value)
+	}
+
+	if len(queries) != 1 {
+		t.Fatalf("expected 1 query, got %d", len(queries))
+	}
+	if queries[0].Region != "us-east-1" {
+		t.Errorf("region error: expected=us-east-1 got=%s", queries[0].Region)
+	}
+	if queries[0].Name != "db" {
+		t.Errorf("name error: expected=db got=%s", queries[0].Name)
+	}
+
+	// second field should come from cache
+	if _, err := secret.RetrieveWithError("my-store:us-east-1:db:uri"); err != nil {
+		t.Fatalf("retrieve: %v", err)
+	}
+	if len(queries) != 1 {
+		t.Errorf("expected cached value, got %d queries", len(queries))
+	}
+}
+
+func TestRegisterBackendLongestPrefix(t *testing.T) {
+
+	secret := New(Options{AwsConfigSource: &AwsConfigSource{}})
+
+	secret.RegisterBackend("aws-secretsmanager-mock", BackendFunc(func(_ context.Context, q Query) (string, error) {
+		return "mock:" + q.Name, nil
+	}))
+
+	value, err := secret.RetrieveWithError("aws-secretsmanager-mock::db")
+	if err != nil {
+		t.Fatalf("retrieve: %v", err)
+	}
+
+	const expected = "mock:db"
+	if value != expected {
+		t.Errorf("secret error: expected=%s got=%s", expected, value)
+	}
+}
+
+func TestRetrieveLiteral(t *testing.T) {
+
+	secret := New(Options{AwsConfigSource: &AwsConfigSource{}})
+
+	const literal = "http://real-db"
+
+	value, err := secret.RetrieveWithError(literal)
+	if err != nil {
+		t.Fatalf("retrieve: %v", err)
+	}
+	if value != literal {
+		t.Errorf("literal error: expected=%s got=%s", literal, value)
+	}
+}
+
+func TestRetrieveContextDeadline(t *testing.T) {
+
+	secret := New(Options{AwsConfigSource: &AwsConfigSource{}})
+
+	secret.RegisterBackend("hung-store", BackendFunc(func(ctx context.Context, _ Query) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err := secret.RetrieveWithErrorContext(ctx, "hung-store::db")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got: %v", err)
+	}
+}
+
+func TestRetrieveConcurrent(t *testing.T) {
+
+	var calls atomic.Int32
+	release := make(chan struct{})
+
+	secret := New(Options{AwsConfigSource: &AwsConfigSource{}})
+
+	secret.RegisterBackend("slow-store", BackendFunc(func(_ context.Context, _ Query) (string, error) {
+		calls.Add(1)
+		<-release
+		return `{"user":"admin","password":"secret"}`, nil
+	}))
+
+	const goroutines = 20
+
+	var wg sync.WaitGroup
+	errs := make(chan error, goroutines)
+
+	for i := range goroutines {
+		field := "user"
+		if i%2 == 1 {
+			field = "password"
+		}
+		wg.Go(func() {
+			if _, err := secret.RetrieveWithError("slow-store:us-east-1:db:" + field); err != nil {
+				errs <- err
+			}
+		})
+	}
+
+	time.Sleep(50 * time.Millisecond) // let goroutines pile up on the query
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Errorf("retrieve: %v", err)
+	}
+
+	if c := calls.Load(); c != 1 {
+		t.Errorf("expected 1 backend call, got %d", c)
+	}
+}
+
+func TestRetrieveSharedQueryOutlivesCaller(t *testing.T) {
+
+	release := make(chan struct{})
+
+	secret := New(Options{AwsConfigSource: &AwsConfigSource{}})
+
+	secret.RegisterBackend("slow-store", BackendFunc(func(ctx context.Context, _ Query) (string, error) {
+		select {
+		case <-release:
+			return "value", nil
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	impatient := make(chan error, 1)
+	go func() {
+		_, err := secret.RetrieveWithErrorContext(ctx, "slow-store::db")
+		impatient <- err
+	}()
+
+	patient := make(chan string, 1)
+	go func() {
+		time.Sleep(5 * time.Millisecond) // join the shared query
+		value, err := secret.RetrieveWithErrorContext(context.Background(), "slow-store::db")
+		if err != nil {
+			t.Errorf("patient caller: %v", err)
+		}
+		patient <- value
+	}()
+
+	if err := <-impatient; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("impatient caller: expected deadline exceeded, got: %v", err)
+	}
+
+	close(release)
+
+	if value := <-patient; value != "value" {
+		t.Errorf("patient caller: expected=value got=%s", value)
+	}
+}
+
+func TestRetrieveSharedQueryBounded(t *testing.T) {
+
+	secret := New(Options{AwsConfigSource: &AwsConfigSource{}, QueryTimeoutSeconds: -1})
+
+	var deadline time.Time
+
+	secret.RegisterBackend("my-store", BackendFunc(func(ctx context.Context, _ Query) (string, error) {
+		deadline, _ = ctx.Deadline()
+		return "value", nil
+	}))
+
+	// caller without deadline, and query timeout disabled
+	if _, err := secret.RetrieveWithError("my-store::db"); err != nil {
+		t.Fatalf("retrieve: %v", err)
+	}
+
+	if deadline.IsZero() || time.Until(deadline) > sharedQueryTimeout {
+		t.Errorf("expected shared query bounded by %v, got deadline: %v", sharedQueryTimeout, deadline)
+	}
+}
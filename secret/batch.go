@@ -0,0 +1,287 @@
+package secret
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// RetrieveMany fetches multiple secrets.
+//
+// Names are grouped by backend and region. Backends implementing
+// BatchBackend receive a single bulk query for each group: the built-in
+// Secrets Manager (BatchGetSecretValue), Parameter Store (GetParameters)
+// and DynamoDB (BatchGetItem) backends. Other names are queried in
+// parallel, up to Options.BatchConcurrency at a time.
+//
+// Cache, stale values and error backoff apply as for Retrieve. Bulk
+// queries are not coalesced with concurrent Retrieve calls for the same
+// secrets, which may then query the store as well: waiting on each other
+// could deadlock concurrent RetrieveMany calls for overlapping names.
+// The map holds values for every name retrieved successfully, literal
+// values included. The error joins errors for names that failed,
+// regardless of Options.FailurePolicy.
+func (s *Secret) RetrieveMany(ctx context.Context, names []string) (map[string]string, error) {
+	const me = "Secret.RetrieveMany"
+
+	values := map[string]string{}
+	var errs []error
+
+	//
+	// parse names and find unique secrets
+	//
+
+	refs := map[string]Reference{}             // name => reference
+	keys := map[string]string{}                // name => cacheKey
+	pending := map[string]*batchItem{}         // cacheKey => secret to fetch
+	raw := map[string]BatchResult{}            // cacheKey => raw secret
+	groups := map[string][]*batchItem{}        // backend:region => secrets for batch backend
+	batchBackends := map[string]BatchBackend{} // backend:region => batch backend
+	var singles []*batchItem                   // secrets for plain backends
+
+	for _, name := range names {
+		if _, seen := values[name]; seen {
+			continue
+		}
+		if _, seen := refs[name]; seen {
+			continue
+		}
+
+		ref, backend, found, errParse := s.resolve(name)
+		if !found {
+			values[name] = name // literal value
+			continue
+		}
+		if errParse != nil {
+			s.options.Printf("%s: parse secret error: %v", me, errParse)
+			if s.options.Strict {
+				errs = append(errs, errParse)
+			} else {
+				values[name] = name
+			}
+			continue
+		}
+
+		refs[name] = ref
+
+		key := backendCacheKey(backend, ref)
+		keys[name] = key
+		if _, seen := pending[key]; seen {
+			continue
+		}
+		if _, seen := raw[key]; seen {
+			continue
+		}
+
+		if value, found := s.cacheGet(key); found {
+			raw[key] = BatchResult{Value: value}
+			continue
+		}
+
+		item := &batchItem{ref: ref, backend: backend}
+		pending[key] = item
+
+		if b, isBatch := backend.(BatchBackend); isBatch {
+			groupKey := ref.Backend + ":" + ref.Region + ":" + ref.Host
+			groups[groupKey] = append(groups[groupKey], item)
+			batchBackends[groupKey] = b
+			continue
+		}
+
+		singles = append(singles, item)
+	}
+
+	//
+	// fetch pending secrets with bounded concurrency
+	//
+
+	sem := make(chan struct{}, s.options.BatchConcurrency)
+	var wg sync.WaitGroup
+
+	// acquire waits for a slot, unless the caller gives up
+	acquire := func() bool {
+		select {
+		case sem <- struct{}{}:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	for groupKey, items := range groups {
+		if !acquire() {
+			for _, item := range items {
+				item.result.Err = ctx.Err()
+			}
+			continue
+		}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			s.retrieveBatch(ctx, batchBackends[groupKey], items)
+		})
+	}
+
+	for _, item := range singles {
+		if !acquire() {
+			item.result.Err = ctx.Err()
+			continue
+		}
+		wg.Go(func() {
+			defer func() { <-sem }()
+			item.result.Value, item.result.Err = s.retrieve(ctx, item.backend, item.ref, false)
+		})
+	}
+
+	wg.Wait()
+
+	for key, item := range pending {
+		raw[key] = item.result
+	}
+
+	//
+	// extract fields
+	//
+
+	for name, ref := range refs {
+		result := raw[keys[name]]
+		if result.Err != nil {
+			errs = append(errs, fmt.Errorf("%s: name='%s': %w", me, name, result.Err))
+			continue
+		}
+		value, errExtract := s.extract(name, ref, result.Value)
+		if errExtract != nil {
+			errs = append(errs, fmt.Errorf("%s: name='%s': %w", me, name, errExtract))
+			continue
+		}
+		values[name] = value
+	}
+
+	return values, errors.Join(errs...)
+}
+
+type batchItem struct {
+	ref     Reference
+	backend Backend
+	result  BatchResult
+}
+
+// retrieveBatch fetches a group of secrets with a single bulk query.
+func (s *Secret) retrieveBatch(ctx context.Context, b BatchBackend, items []*batchItem) {
+	const me = "Secret.retrieveBatch"
+
+	var queries []Query
+	var fetched []*batchItem
+
+	for _, item := range items {
+		key := backendCacheKey(b, item.ref)
+		if errBackoff := s.backoff(key); errBackoff != nil {
+			item.result.Value, item.result.Err = s.staleIfError(key, errBackoff)
+			continue
+		}
+		queries = append(queries, s.newQuery(item.ref))
+		fetched = append(fetched, item)
+	}
+
+	if len(queries) == 0 {
+		return
+	}
+
+	ctxQuery, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	results := b.QueryBatch(ctxQuery, queries)
+
+	if len(results) != len(queries) {
+		err := fmt.Errorf("%s: backend returned %d results for %d queries",
+			me, len(results), len(queries))
+		results = make([]BatchResult, len(queries))
+		for i := range results {
+			results[i].Err = err
+		}
+	}
+
+	for i, item := range fetched {
+		key := backendCacheKey(b, item.ref)
+		r := results[i]
+		if r.Err != nil {
+			err := &QueryError{Prefix: item.ref.Backend, Region: item.ref.Region, Err: classifyError(r.Err)}
+			s.options.Printf("%s: secret query error: %v", me, err)
+			if !callerGaveUp(ctx, r.Err) {
+				s.recordFailure(key, err)
+			}
+			item.result.Value, item.result.Err = s.staleIfError(key, err)
+			continue
+		}
+		s.clearFailure(key)
+		s.cachePut(key, r.Value)
+		item.result = r
+	}
+}
+
+// batchByKey helps backends implement QueryBatch.
+//
+// keyOf identifies the item each query refers to. Unique keys are
+// passed to fetch in chunks of up to size keys. valueOf gets the
+// query value from the fetched item. Keys missing from the map
+// returned by fetch are reported as ErrNotFound.
+func batchByKey[T any](queries []Query, size int,
+	keyOf func(q Query) (string, error),
+	fetch func(keys []string) (map[string]T, error),
+	valueOf func(q Query, item T) (string, error)) []BatchResult {
+
+	results := make([]BatchResult, len(queries))
+
+	keys := make([]string, len(queries))
+	var unique []string
+	seen := map[string]bool{}
+
+	for i, q := range queries {
+		key, err := keyOf(q)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		keys[i] = key
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, key)
+		}
+	}
+
+	items := map[string]T{}
+	failed := map[string]error{}
+
+	for begin := 0; begin < len(unique); begin += size {
+		chunk := unique[begin:min(begin+size, len(unique))]
+		found, err := fetch(chunk)
+		if err != nil {
+			for _, key := range chunk {
+				failed[key] = err
+			}
+			continue
+		}
+		for key, item := range found {
+			items[key] = item
+		}
+	}
+
+	for i, q := range queries {
+		if results[i].Err != nil {
+			continue
+		}
+		key := keys[i]
+		if err, isFailed := failed[key]; isFailed {
+			results[i].Err = err
+			continue
+		}
+		item, found := items[key]
+		if !found {
+			results[i].Err = fmt.Errorf("%w: %s", ErrNotFound, q.Name)
+			continue
+		}
+		results[i].Value, results[i].Err = valueOf(q, item)
+	}
+
+	return results
+}
@@ -0,0 +1,210 @@
+package secret
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testBatchBackend struct {
+	lock    sync.Mutex
+	batches [][]string
+}
+
+func (b *testBatchBackend) Query(_ context.Context, q Query) (string, error) {
+	return "", fmt.Errorf("unexpected single query: %s", q.Name)
+}
+
+func (b *testBatchBackend) QueryBatch(_ context.Context, queries []Query) []BatchResult {
+	var names []string
+	results := make([]BatchResult, len(queries))
+	for i, q := range queries {
+		names = append(names, q.Region+"/"+q.Name)
+		if q.Name == "missing" {
+			results[i].Err = fmt.Errorf("%w: %s", ErrNotFound, q.Name)
+			continue
+		}
+		results[i].Value = fmt.Sprintf(`{"name":"%s","region":"%s"}`, q.Name, q.Region)
+	}
+	b.lock.Lock()
+	b.batches = append(b.batches, names)
+	b.lock.Unlock()
+	return results
+}
+
+func TestRetrieveMany(t *testing.T) {
+
+	batch := &testBatchBackend{}
+	var singles atomic.Int32
+
+	secret := New(Options{
+		AwsConfigSource: &AwsConfigSource{},
+		Backends: map[string]Backend{
+			"batch-store": batch,
+			"plain-store": BackendFunc(func(_ context.Context, q Query) (string, error) {
+				singles.Add(1)
+				return "plain-" + q.Name, nil
+			}),
+		},
+	})
+
+	names := []string{
+		"batch-store:us-east-1:a:name",
+		"batch-store:us-east-1:a:region", // same secret, another field
+		"batch-store:us-east-1:b:name",
+		"batch-store:us-east-2:c:name",
+		"batch-store:us-east-2:missing:name",
+		"plain-store::x",
+		"plain-store::y",
+		"plain-store::y", // duplicate
+		"literal",
+	}
+
+	values, err := secret.RetrieveMany(context.Background(), names)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got: %v", err)
+	}
+
+	expected := map[string]string{
+		"batch-store:us-east-1:a:name":   "a",
+		"batch-store:us-east-1:a:region": "us-east-1",
+		"batch-store:us-east-1:b:name":   "b",
+		"batch-store:us-east-2:c:name":   "c",
+		"plain-store::x":                 "plain-x",
+		"plain-store::y":                 "plain-y",
+		"literal":                        "literal",
+	}
+
+	if len(values) != len(expected) {
+		t.Errorf("expected %d values, got %d: %v", len(expected), len(values), values)
+	}
+	for k, v := range expected {
+		if values[k] != v {
+			t.Errorf("name=%s expected=%s got=%s", k, v, values[k])
+		}
+	}
+
+	if len(batch.batches) != 2 {
+		t.Fatalf("expected 2 batches (one per region), got %d: %v", len(batch.batches), batch.batches)
+	}
+	for _, b := range batch.batches {
+		slices.Sort(b)
+		if !slices.Equal(b, []string{"us-east-1/a", "us-east-1/b"}) &&
+			!slices.Equal(b, []string{"us-east-2/c", "us-east-2/missing"}) {
+			t.Errorf("unexpected batch: %v", b)
+		}
+	}
+
+	if n := singles.Load(); n != 2 {
+		t.Errorf("expected 2 single queries, got %d", n)
+	}
+
+	// second round comes from cache, except the missing secret
+
+	if _, err := secret.RetrieveMany(context.Background(), names); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got: %v", err)
+	}
+	if len(batch.batches) != 3 || len(batch.batches[2]) != 1 {
+		t.Errorf("expected single batch for missing secret, got: %v", batch.batches)
+	}
+	if n := singles.Load(); n != 2 {
+		t.Errorf("expected cached values, got %d single queries", n)
+	}
+
+	// values are shared with Retrieve
+	if v := secret.Retrieve("batch-store:us-east-1:b:region"); v != "us-east-1" {
+		t.Errorf("expected cached value, got: %s", v)
+	}
+	if len(batch.batches) != 3 {
+		t.Errorf("expected no query, got: %v", batch.batches)
+	}
+}
+
+func TestBatchByKey(t *testing.T) {
+
+	queries := []Query{{Name: "a"}, {Name: "b"}, {Name: "a"}, {Name: "bad"}, {Name: "c"}, {Name: "missing"}}
+
+	var chunks [][]string
+
+	keyOf := func(q Query) (string, error) {
+		if q.Name == "bad" {
+			return "", ErrMalformedReference
+		}
+		return q.Name, nil
+	}
+
+	fetch := func(keys []string) (map[string]string, error) {
+		chunks = append(chunks, keys)
+		found := map[string]string{}
+		for _, k := range keys {
+			if k != "missing" {
+				found[k] = "value-" + k
+			}
+		}
+		return found, nil
+	}
+
+	valueOf := func(_ Query, v string) (string, error) { return v, nil }
+
+	results := batchByKey(queries, 2, keyOf, fetch, valueOf)
+
+	if len(chunks) != 2 || !slices.Equal(chunks[0], []string{"a", "b"}) || !slices.Equal(chunks[1], []string{"c", "missing"}) {
+		t.Errorf("unexpected chunks: %v", chunks)
+	}
+
+	expected := []string{"value-a", "value-b", "value-a", "", "value-c", ""}
+	for i, r := range results {
+		if r.Value != expected[i] {
+			t.Errorf("query %d: expected=%s got=%s", i, expected[i], r.Value)
+		}
+	}
+	if !errors.Is(results[3].Err, ErrMalformedReference) {
+		t.Errorf("expected ErrMalformedReference, got: %v", results[3].Err)
+	}
+	if !errors.Is(results[5].Err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got: %v", results[5].Err)
+	}
+}
+
+func TestRetrieveManyConcurrencyCancel(t *testing.T) {
+
+	secret := New(Options{AwsConfigSource: &AwsConfigSource{}, BatchConcurrency: 1})
+
+	release := make(chan struct{})
+	var batches atomic.Int32
+
+	secret.RegisterBackend("slow-store", batchBackend{
+		func(_ context.Context, q Query) (string, error) {
+			return "", fmt.Errorf("unexpected single query: %s", q.Name)
+		},
+		func(_ context.Context, queries []Query) []BatchResult {
+			batches.Add(1)
+			<-release // ignores ctx, holding the only slot
+			return make([]BatchResult, len(queries))
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	go func() {
+		<-ctx.Done()
+		time.Sleep(20 * time.Millisecond) // caller gave up before the slot is free
+		close(release)
+	}()
+
+	// two regions: two groups, one slot
+	_, err := secret.RetrieveMany(ctx, []string{"slow-store:us-east-1:db", "slow-store:us-east-2:db"})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got: %v", err)
+	}
+
+	if n := batches.Load(); n != 1 {
+		t.Errorf("expected no query after caller gave up, got %d batches", n)
+	}
+}
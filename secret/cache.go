@@ -0,0 +1,133 @@
+package secret
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Cache stores values retrieved from backends.
+//
+// Keys are namespaced by backend prefix and region:
+//
+//	aws-secretsmanager:us-east-1:database
+//
+// Values are the raw secrets, before JSON field extraction, so that
+// multiple fields from the same secret are fetched with a single query.
+//
+// A Cache may keep entries beyond their TTL, up to Expires. The Secret
+// decides whether an entry is fresh or can be served stale.
+//
+// Implementations must be safe for concurrent use by multiple goroutines.
+// A custom implementation can persist entries, for instance in an
+// encrypted on-disk cache that survives restarts.
+type Cache interface {
+	Get(key string) (CacheEntry, bool)
+	Put(key string, entry CacheEntry)
+	Delete(key string)
+}
+
+// CacheEntry holds a cached value.
+type CacheEntry struct {
+	Value   string
+	Created time.Time
+	TTL     time.Duration // entry is fresh until Created+TTL
+	Expires time.Time     // entry can be discarded after Expires
+}
+
+// Fresh reports whether the entry is within its TTL.
+func (e CacheEntry) Fresh(now time.Time) bool {
+	return now.Sub(e.Created) < e.TTL
+}
+
+// Expired reports whether the entry can be discarded.
+func (e CacheEntry) Expired(now time.Time) bool {
+	return !now.Before(e.Expires)
+}
+
+// LRUCache is the default Cache implementation.
+// It holds up to a maximum number of entries in memory,
+// evicting the least recently used entry when full.
+type LRUCache struct {
+	maxEntries int
+	entries    map[string]*list.Element
+	order      *list.List // front=most recently used
+	lock       sync.Mutex
+}
+
+type lruItem struct {
+	key   string
+	entry CacheEntry
+}
+
+// NewLRUCache creates an in-memory LRU cache.
+// maxEntries: 0=unlimited
+func NewLRUCache(maxEntries int) *LRUCache {
+	return &LRUCache{
+		maxEntries: maxEntries,
+		entries:    map[string]*list.Element{},
+		order:      list.New(),
+	}
+}
+
+// Get retrieves an entry. Expired entries are discarded.
+func (c *LRUCache) Get(key string) (CacheEntry, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	elem, found := c.entries[key]
+	if !found {
+		return CacheEntry{}, false
+	}
+
+	item := elem.Value.(*lruItem)
+
+	if item.entry.Expired(time.Now()) {
+		c.remove(elem)
+		return CacheEntry{}, false
+	}
+
+	c.order.MoveToFront(elem)
+
+	return item.entry, true
+}
+
+// Put saves an entry, evicting the least recently used entry if full.
+func (c *LRUCache) Put(key string, entry CacheEntry) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if elem, found := c.entries[key]; found {
+		elem.Value.(*lruItem).entry = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&lruItem{key: key, entry: entry})
+
+	if c.maxEntries > 0 && c.order.Len() > c.maxEntries {
+		c.remove(c.order.Back())
+	}
+}
+
+// Delete removes an entry.
+func (c *LRUCache) Delete(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if elem, found := c.entries[key]; found {
+		c.remove(elem)
+	}
+}
+
+// Len returns the number of entries in the cache.
+func (c *LRUCache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.order.Len()
+}
+
+func (c *LRUCache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*lruItem).key)
+}
@@ -0,0 +1,94 @@
+package secret
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLRUCache(t *testing.T) {
+
+	c := NewLRUCache(2)
+
+	now := time.Now()
+	entry := func(v string) CacheEntry {
+		return CacheEntry{Value: v, Created: now, TTL: time.Minute, Expires: now.Add(time.Minute)}
+	}
+
+	c.Put("a", entry("1"))
+	c.Put("b", entry("2"))
+	c.Get("a") // a becomes most recently used
+	c.Put("c", entry("3"))
+
+	if _, found := c.Get("b"); found {
+		t.Errorf("expected b to be evicted")
+	}
+	if e, found := c.Get("a"); !found || e.Value != "1" {
+		t.Errorf("expected a=1, got found=%t value=%s", found, e.Value)
+	}
+	if e, found := c.Get("c"); !found || e.Value != "3" {
+		t.Errorf("expected c=3, got found=%t value=%s", found, e.Value)
+	}
+	if c.Len() != 2 {
+		t.Errorf("expected 2 entries, got %d", c.Len())
+	}
+
+	expired := entry("4")
+	expired.Expires = now.Add(-time.Second)
+	c.Put("d", expired)
+	if _, found := c.Get("d"); found {
+		t.Errorf("expected d to be expired")
+	}
+
+	c.Delete("a")
+	if _, found := c.Get("a"); found {
+		t.Errorf("expected a to be deleted")
+	}
+}
+
+func TestCacheNamespace(t *testing.T) {
+
+	calls := map[string]int{}
+
+	secret := New(Options{AwsConfigSource: &AwsConfigSource{}})
+
+	for _, prefix := range []string{"store-a", "store-b"} {
+		secret.RegisterBackend(prefix, BackendFunc(func(_ context.Context, _ Query) (string, error) {
+			calls[prefix]++
+			return prefix, nil
+		}))
+	}
+
+	for range 2 {
+		// scalar values are also cached
+		if v := secret.Retrieve("store-a::db"); v != "store-a" {
+			t.Errorf("expected=store-a got=%s", v)
+		}
+		if v := secret.Retrieve("store-b::db"); v != "store-b" {
+			t.Errorf("expected=store-b got=%s", v)
+		}
+	}
+
+	if calls["store-a"] != 1 || calls["store-b"] != 1 {
+		t.Errorf("expected one call per store, got: %v", calls)
+	}
+}
+
+func TestCacheDisabled(t *testing.T) {
+
+	var calls int
+
+	secret := New(Options{AwsConfigSource: &AwsConfigSource{}, CacheTTLSeconds: -1})
+
+	secret.RegisterBackend("store", BackendFunc(func(_ context.Context, _ Query) (string, error) {
+		calls++
+		return `{"uri":"x"}`, nil
+	}))
+
+	secret.Retrieve("store::db:uri")
+	secret.Retrieve("store::db:uri")
+
+	if calls != 2 {
+		t.Errorf("expected 2 calls with cache disabled, got %d", calls)
+	}
+}
@@ -2,51 +2,68 @@ package secret
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"math/big"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
-	"github.com/udhos/boilerplate/boilerplate"
 )
 
-// aws-dynamodb:region:table_name,key_name,key_value,value_attr[:field_name]
-func queryDynamoDb(_ /*debug*/ bool, _ /*printf*/ boilerplate.FuncPrintf, getAwsConfig AwsConfigSolver, dynamoOptions string) (string, error) {
+/*
+aws-dynamodb:region:table_name,key_name,key_value,value_attr[,option=value...][:field_name]
+
+secret+aws-dynamodb://region/table_name?key=key_name&value=key_value&attr=value_attr[&option=value...][&field=field_name]
+
+Options:
+
+	key-type=S|N|B    partition key type, defaults to S (B is base64-encoded)
+	sort-key=name     sort key name, for tables with composite primary key
+	sort-value=value  sort key value
+	sort-type=S|N|B   sort key type, defaults to S
+	consistent=true   strongly consistent read
+
+Attribute values of any type are supported: strings are returned as is,
+numbers and booleans as text, binary as base64, and maps, lists and sets as JSON,
+so that nested fields can be extracted:
+
+export DB_URI=aws-dynamodb:us-east-1:config,app,billing,settings,sort-key=env,sort-value=prod:db.uri
+*/
+func queryDynamoDb(ctx context.Context, q Query) (string, error) {
 	const me = "queryDynamoDb"
 
-	options := strings.SplitN(dynamoOptions, ",", 4)
-	if len(options) < 4 {
-		return "", fmt.Errorf("%s: bad dynamodb options, expecting 4 fields - got: '%s'",
-			me, dynamoOptions)
+	opt, errOptions := parseDynamoOptions(q)
+	if errOptions != nil {
+		return "", fmt.Errorf("%w: %s: bad dynamodb options: %w",
+			ErrMalformedReference, me, errOptions)
 	}
 
-	table := options[0]
-	keyName := options[1]
-	keyValue := options[2]
-	attrField := options[3]
+	key, errKey := opt.key()
+	if errKey != nil {
+		return "", fmt.Errorf("%w: %s: %w", ErrMalformedReference, me, errKey)
+	}
 
-	awsConfig, errAwsConfig := getAwsConfig.get()
+	awsConfig, errAwsConfig := q.AwsConfig(ctx)
 	if errAwsConfig != nil {
 		return "", errAwsConfig
 	}
 
 	dc := dynamodb.NewFromConfig(awsConfig, func(o *dynamodb.Options) {
-		if endpoint := getAwsConfig.endpointURL(); endpoint != "" {
+		if endpoint := q.EndpointURL(); endpoint != "" {
 			o.BaseEndpoint = aws.String(endpoint)
 		}
 	})
 
-	av, errMarshal := attributevalue.Marshal(keyValue)
-	if errMarshal != nil {
-		return "", errMarshal
-	}
-
-	key := map[string]types.AttributeValue{keyName: av}
-
-	response, errGet := dc.GetItem(context.TODO(), &dynamodb.GetItemInput{
-		Key: key, TableName: aws.String(table),
+	response, errGet := dc.GetItem(ctx, &dynamodb.GetItemInput{
+		Key:            key,
+		TableName:      aws.String(opt.table),
+		ConsistentRead: aws.Bool(opt.consistent),
 	})
 
 	if errGet != nil {
@@ -54,22 +71,321 @@ func queryDynamoDb(_ /*debug*/ bool, _ /*printf*/ boilerplate.FuncPrintf, getAws
 	}
 
 	if len(response.Item) == 0 {
-		return "", fmt.Errorf("%s: item not found: '%s'",
-			me, dynamoOptions)
+		return "", fmt.Errorf("%w: %s: item not found: '%s'",
+			ErrNotFound, me, q.Name)
+	}
+
+	return dynamoAttr(me, q.Name, response.Item, opt.attr)
+}
+
+type dynamoKey struct {
+	name  string
+	typ   string // S|N|B
+	value string
+}
+
+type dynamoOptions struct {
+	table      string
+	partition  dynamoKey
+	sort       dynamoKey // empty name for tables without sort key
+	attr       string
+	consistent bool
+}
+
+// dynamoOptionNames lists named options, besides positional key, value and attr.
+var dynamoOptionNames = []string{"key-type", "sort-key", "sort-value", "sort-type", "consistent"}
+
+func parseDynamoOptions(q Query) (dynamoOptions, error) {
+
+	options := q.Reference.Options
+
+	if q.Reference.URI {
+		for name := range options {
+			if !slices.Contains(dynamoOptionNames, name) && name != "key" && name != "value" && name != "attr" {
+				return dynamoOptions{}, fmt.Errorf("unknown option '%s'", name)
+			}
+		}
+	} else {
+		q.Name, options = trailingOptions(q.Name, dynamoOptionNames)
+	}
+
+	positional, errPositional := referenceOptions(q, ",", "key", "value", "attr")
+	if errPositional != nil {
+		return dynamoOptions{}, errPositional
+	}
+
+	opt := dynamoOptions{
+		table:     positional[0],
+		partition: dynamoKey{name: positional[1], typ: options.Get("key-type"), value: positional[2]},
+		attr:      positional[3],
+	}
+
+	if options.Has("sort-key") != options.Has("sort-value") {
+		return opt, fmt.Errorf("sort-key and sort-value must be given together")
+	}
+	if options.Has("sort-key") {
+		opt.sort = dynamoKey{name: options.Get("sort-key"), typ: options.Get("sort-type"), value: options.Get("sort-value")}
+	}
+
+	for _, k := range []*dynamoKey{&opt.partition, &opt.sort} {
+		if k.typ == "" {
+			k.typ = "S"
+		}
+		k.typ = strings.ToUpper(k.typ)
+		if _, err := k.attributeValue(); err != nil {
+			return opt, err
+		}
+	}
+
+	if c := options.Get("consistent"); c != "" {
+		consistent, errBool := strconv.ParseBool(c)
+		if errBool != nil {
+			return opt, fmt.Errorf("bad consistent option: %w", errBool)
+		}
+		opt.consistent = consistent
+	}
+
+	return opt, nil
+}
+
+// key builds primary key for the item.
+func (o dynamoOptions) key() (map[string]types.AttributeValue, error) {
+	key := map[string]types.AttributeValue{}
+	for _, k := range []dynamoKey{o.partition, o.sort} {
+		if k.name == "" {
+			continue
+		}
+		av, err := k.attributeValue()
+		if err != nil {
+			return nil, err
+		}
+		key[k.name] = av
+	}
+	return key, nil
+}
+
+// batchKey identifies the item within a batch. Numerically equal
+// keys, like 1 and 1.0, identify the same item, since BatchGetItem
+// rejects duplicate keys.
+func (o dynamoOptions) batchKey() string {
+	return strings.Join([]string{
+		o.table,
+		o.partition.name, o.partition.typ, o.partition.canonical(),
+		o.sort.name, o.sort.typ, o.sort.canonical(),
+	}, "\x00")
+}
+
+// canonical returns the key value in canonical form: numbers are
+// normalized, so that 1, 1.0 and 1e0 have the same text.
+func (k dynamoKey) canonical() string {
+	if k.typ == "N" {
+		if r, ok := new(big.Rat).SetString(k.value); ok {
+			return r.RatString()
+		}
 	}
+	return k.value
+}
 
-	body := map[string]string{}
+func (k dynamoKey) attributeValue() (types.AttributeValue, error) {
+	switch k.typ {
+	case "S":
+		return &types.AttributeValueMemberS{Value: k.value}, nil
+	case "N":
+		if _, ok := new(big.Rat).SetString(k.value); !ok {
+			return nil, fmt.Errorf("bad number for key '%s': '%s'", k.name, k.value)
+		}
+		return &types.AttributeValueMemberN{Value: k.value}, nil
+	case "B":
+		b, err := base64.StdEncoding.DecodeString(k.value)
+		if err != nil {
+			return nil, fmt.Errorf("bad base64 binary for key '%s': %w", k.name, err)
+		}
+		return &types.AttributeValueMemberB{Value: b}, nil
+	}
+	return nil, fmt.Errorf("bad type '%s' for key '%s', expecting S|N|B", k.typ, k.name)
+}
 
-	errUnmarshal := attributevalue.UnmarshalMap(response.Item, &body)
-	if errUnmarshal != nil {
-		return "", errUnmarshal
+// matches reports whether the item attribute holds the key value.
+func (k dynamoKey) matches(av types.AttributeValue) bool {
+	switch v := av.(type) {
+	case *types.AttributeValueMemberS:
+		return k.typ == "S" && v.Value == k.value
+	case *types.AttributeValueMemberN:
+		if k.typ != "N" {
+			return false
+		}
+		x, okX := new(big.Rat).SetString(v.Value)
+		y, okY := new(big.Rat).SetString(k.value)
+		return okX && okY && x.Cmp(y) == 0
+	case *types.AttributeValueMemberB:
+		return k.typ == "B" && base64.StdEncoding.EncodeToString(v.Value) == k.value
 	}
+	return false
+}
 
-	value, found := body[attrField]
+// dynamoAttr extracts attribute from item.
+func dynamoAttr(me, dynamoOptions string, item map[string]types.AttributeValue, attrField string) (string, error) {
+	av, found := item[attrField]
 	if !found {
-		return "", fmt.Errorf("%s: item attribute '%s' not found: '%s'",
-			me, attrField, dynamoOptions)
+		return "", fmt.Errorf("%w: %s: item attribute '%s' not found: '%s'",
+			ErrFieldMissing, me, attrField, dynamoOptions)
+	}
+
+	return fieldString(dynamoValue(av))
+}
+
+// dynamoValue converts attribute value into a value suitable for JSON encoding.
+func dynamoValue(av types.AttributeValue) any {
+	switch v := av.(type) {
+	case *types.AttributeValueMemberS:
+		return v.Value
+	case *types.AttributeValueMemberN:
+		return json.Number(v.Value)
+	case *types.AttributeValueMemberB:
+		return base64.StdEncoding.EncodeToString(v.Value)
+	case *types.AttributeValueMemberBOOL:
+		return v.Value
+	case *types.AttributeValueMemberNULL:
+		return nil
+	case *types.AttributeValueMemberM:
+		m := make(map[string]any, len(v.Value))
+		for k, child := range v.Value {
+			m[k] = dynamoValue(child)
+		}
+		return m
+	case *types.AttributeValueMemberL:
+		list := make([]any, 0, len(v.Value))
+		for _, child := range v.Value {
+			list = append(list, dynamoValue(child))
+		}
+		return list
+	case *types.AttributeValueMemberSS:
+		list := make([]any, 0, len(v.Value))
+		for _, s := range v.Value {
+			list = append(list, s)
+		}
+		return list
+	case *types.AttributeValueMemberNS:
+		list := make([]any, 0, len(v.Value))
+		for _, n := range v.Value {
+			list = append(list, json.Number(n))
+		}
+		return list
+	case *types.AttributeValueMemberBS:
+		list := make([]any, 0, len(v.Value))
+		for _, b := range v.Value {
+			list = append(list, base64.StdEncoding.EncodeToString(b))
+		}
+		return list
+	}
+	return nil
+}
+
+// queryDynamoDbBatch fetches items with BatchGetItem, up to 100 items per call.
+func queryDynamoDbBatch(ctx context.Context, queries []Query) []BatchResult {
+	const me = "queryDynamoDbBatch"
+
+	awsConfig, errAwsConfig := queries[0].AwsConfig(ctx)
+
+	dc := dynamodb.NewFromConfig(awsConfig, func(o *dynamodb.Options) {
+		if endpoint := queries[0].EndpointURL(); endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	})
+
+	specs := map[string]dynamoOptions{} // batch key => options
+
+	keyOf := func(q Query) (string, error) {
+		opt, errOptions := parseDynamoOptions(q)
+		if errOptions != nil {
+			return "", fmt.Errorf("%w: %s: bad dynamodb options: %w",
+				ErrMalformedReference, me, errOptions)
+		}
+		k := opt.batchKey()
+		if prev, found := specs[k]; found && prev.consistent {
+			opt.consistent = true
+		}
+		specs[k] = opt
+		return k, nil
+	}
+
+	fetch := func(keys []string) (map[string]map[string]types.AttributeValue, error) {
+		if errAwsConfig != nil {
+			return nil, errAwsConfig
+		}
+
+		request := map[string]types.KeysAndAttributes{}
+		tableSpecs := map[string][]string{} // table => batch keys
+
+		for _, k := range keys {
+			opt := specs[k]
+
+			key, errKey := opt.key()
+			if errKey != nil {
+				return nil, errKey
+			}
+
+			ka := request[opt.table]
+			ka.Keys = append(ka.Keys, key)
+			if opt.consistent {
+				ka.ConsistentRead = aws.Bool(true) // stronger read serves all queries
+			}
+			request[opt.table] = ka
+
+			tableSpecs[opt.table] = append(tableSpecs[opt.table], k)
+		}
+
+		found := map[string]map[string]types.AttributeValue{}
+
+		const maxAttempts = 5
+
+		for attempt := 0; len(request) > 0; attempt++ {
+			if attempt >= maxAttempts {
+				return nil, fmt.Errorf("%w: %s: unprocessed keys after %d attempts",
+					ErrBackendUnavailable, me, maxAttempts)
+			}
+
+			if attempt > 0 {
+				// back off before retrying unprocessed keys
+				select {
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				case <-time.After(50 * time.Millisecond << attempt):
+				}
+			}
+
+			response, errBatch := dc.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{
+				RequestItems: request,
+			})
+			if errBatch != nil {
+				return nil, errBatch
+			}
+
+			for table, items := range response.Responses {
+				for _, item := range items {
+					for _, k := range tableSpecs[table] {
+						opt := specs[k]
+						if !opt.partition.matches(item[opt.partition.name]) {
+							continue
+						}
+						if opt.sort.name != "" && !opt.sort.matches(item[opt.sort.name]) {
+							continue
+						}
+						found[k] = item
+					}
+				}
+			}
+
+			request = response.UnprocessedKeys
+		}
+
+		return found, nil
+	}
+
+	valueOf := func(q Query, item map[string]types.AttributeValue) (string, error) {
+		opt, _ := parseDynamoOptions(q)
+		return dynamoAttr(me, q.Name, item, opt.attr)
 	}
 
-	return value, nil
+	return batchByKey(queries, 100, keyOf, fetch, valueOf)
 }
@@ -0,0 +1,143 @@
+package secret
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+type dynamoOptionsTestCase struct {
+	name          string
+	reference     string
+	expectedError bool
+	expected      dynamoOptions
+}
+
+var dynamoOptionsTestTable = []dynamoOptionsTestCase{
+	{"legacy", "aws-dynamodb:us-east-1:parameters,parameter,mongodb,value:uri", expectOk,
+		dynamoOptions{table: "parameters", partition: dynamoKey{"parameter", "S", "mongodb"}, sort: dynamoKey{"", "S", ""}, attr: "value"}},
+	{"legacy composite", "aws-dynamodb:us-east-1:config,app,billing,settings,sort-key=env,sort-value=prod,consistent=true:db.uri", expectOk,
+		dynamoOptions{table: "config", partition: dynamoKey{"app", "S", "billing"}, sort: dynamoKey{"env", "S", "prod"}, attr: "settings", consistent: true}},
+	{"legacy typed", "aws-dynamodb:us-east-1:config,id,42,settings,key-type=N,sort-key=version,sort-value=AQI=,sort-type=b", expectOk,
+		dynamoOptions{table: "config", partition: dynamoKey{"id", "N", "42"}, sort: dynamoKey{"version", "B", "AQI="}, attr: "settings"}},
+	{"legacy bad number", "aws-dynamodb:us-east-1:config,id,x,settings,key-type=N", expectError, dynamoOptions{}},
+	{"legacy bad type", "aws-dynamodb:us-east-1:config,id,x,settings,key-type=Z", expectError, dynamoOptions{}},
+	{"legacy sort-key alone", "aws-dynamodb:us-east-1:config,id,x,settings,sort-key=env", expectError, dynamoOptions{}},
+	{"legacy missing attr", "aws-dynamodb:us-east-1:config,id,x", expectError, dynamoOptions{}},
+	{"uri composite", "secret+aws-dynamodb://us-east-1/config?key=app&value=billing&attr=settings&sort-key=env&sort-value=prod&field=db.uri", expectOk,
+		dynamoOptions{table: "config", partition: dynamoKey{"app", "S", "billing"}, sort: dynamoKey{"env", "S", "prod"}, attr: "settings"}},
+	{"uri unknown option", "secret+aws-dynamodb://us-east-1/config?key=app&value=billing&attr=settings&consistentRead=true", expectError, dynamoOptions{}},
+}
+
+func TestParseDynamoOptions(t *testing.T) {
+	for _, data := range dynamoOptionsTestTable {
+		t.Run(data.name, func(t *testing.T) {
+			ref, errRef := ParseReference(data.reference)
+			if errRef != nil {
+				t.Fatalf("parse reference: %v", errRef)
+			}
+			opt, err := parseDynamoOptions(Query{Region: ref.Region, Name: ref.Location, Reference: ref})
+			if data.expectedError {
+				if err == nil {
+					t.Errorf("expected error, got: %+v", opt)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if opt != data.expected {
+				t.Errorf("expected=%+v got=%+v", data.expected, opt)
+			}
+		})
+	}
+}
+
+func TestDynamoKeyMatches(t *testing.T) {
+	n := dynamoKey{"id", "N", "42"}
+	if !n.matches(&types.AttributeValueMemberN{Value: "42.0"}) {
+		t.Errorf("expected number match")
+	}
+	if n.matches(&types.AttributeValueMemberS{Value: "42"}) {
+		t.Errorf("unexpected match for string")
+	}
+	b := dynamoKey{"id", "B", "AQI="}
+	if !b.matches(&types.AttributeValueMemberB{Value: []byte{1, 2}}) {
+		t.Errorf("expected binary match")
+	}
+	if n.matches(nil) {
+		t.Errorf("unexpected match for missing attribute")
+	}
+}
+
+func TestDynamoBatchKey(t *testing.T) {
+	key := func(value string) string {
+		return dynamoOptions{table: "config", partition: dynamoKey{"id", "N", value}, attr: "settings"}.batchKey()
+	}
+	for _, v := range []string{"1.0", "1e0", "01", "1.000"} {
+		if key(v) != key("1") {
+			t.Errorf("expected same batch key for 1 and %s", v)
+		}
+	}
+	if key("0.10") != key("0.1") {
+		t.Errorf("expected same batch key for 0.1 and 0.10")
+	}
+	if key("2") == key("1") {
+		t.Errorf("unexpected same batch key for 1 and 2")
+	}
+	s := func(value string) string {
+		return dynamoOptions{table: "config", partition: dynamoKey{"id", "S", value}, attr: "settings"}.batchKey()
+	}
+	if s("1.0") == s("1") {
+		t.Errorf("unexpected same batch key for strings 1 and 1.0")
+	}
+}
+
+func TestDynamoAttr(t *testing.T) {
+	item := map[string]types.AttributeValue{
+		"s":    &types.AttributeValueMemberS{Value: "text"},
+		"n":    &types.AttributeValueMemberN{Value: "27017"},
+		"bool": &types.AttributeValueMemberBOOL{Value: true},
+		"b":    &types.AttributeValueMemberB{Value: []byte{1, 2}},
+		"m": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+			"db": &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+				"port": &types.AttributeValueMemberN{Value: "5432"},
+				"tags": &types.AttributeValueMemberSS{Value: []string{"a", "b"}},
+			}},
+			"null": &types.AttributeValueMemberNULL{Value: true},
+		}},
+		"l": &types.AttributeValueMemberL{Value: []types.AttributeValue{
+			&types.AttributeValueMemberS{Value: "x"},
+			&types.AttributeValueMemberN{Value: "1.5"},
+		}},
+	}
+
+	expected := map[string]string{
+		"s":    "text",
+		"n":    "27017",
+		"bool": "true",
+		"b":    "AQI=",
+		"m":    `{"db":{"port":5432,"tags":["a","b"]},"null":null}`,
+		"l":    `["x",1.5]`,
+	}
+
+	for attr, e := range expected {
+		v, err := dynamoAttr("test", "test", item, attr)
+		if err != nil {
+			t.Errorf("attr=%s: %v", attr, err)
+			continue
+		}
+		if v != e {
+			t.Errorf("attr=%s expected=%s got=%s", attr, e, v)
+		}
+	}
+
+	if v, found, _ := extractField(expected["m"], "db.port"); !found || v != "5432" {
+		t.Errorf("nested field: found=%t value=%s", found, v)
+	}
+
+	if _, err := dynamoAttr("test", "test", item, "missing"); !errors.Is(err, ErrFieldMissing) {
+		t.Errorf("expected ErrFieldMissing, got: %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+package secret
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	vault "github.com/hashicorp/vault/api"
+)
+
+// Sentinel errors returned by Secret for every backend.
+// Use errors.Is to test for them:
+//
+//	value, err := s.RetrieveWithError(name)
+//	if errors.Is(err, secret.ErrNotFound) {
+//		// ...
+//	}
+var (
+	ErrNotFound           = errors.New("secret not found")
+	ErrAccessDenied       = errors.New("access denied")
+	ErrMalformedReference = errors.New("malformed secret reference")
+	ErrBackendUnavailable = errors.New("backend unavailable")
+	ErrFieldMissing       = errors.New("field missing from secret")
+)
+
+// QueryError records a failed backend query.
+// Use errors.As to retrieve it:
+//
+//	var queryErr *secret.QueryError
+//	if errors.As(err, &queryErr) {
+//		log.Printf("store %s failed: %v", queryErr.Prefix, queryErr.Err)
+//	}
+type QueryError struct {
+	Prefix string // backend prefix
+	Region string
+	Err    error // error wrapping one of the sentinel errors, if classified
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("%s query error: region='%s': %v", e.Prefix, e.Region, e.Err)
+}
+
+func (e *QueryError) Unwrap() error {
+	return e.Err
+}
+
+var sentinels = []error{
+	ErrNotFound,
+	ErrAccessDenied,
+	ErrMalformedReference,
+	ErrBackendUnavailable,
+	ErrFieldMissing,
+}
+
+// classifyError wraps a backend error with the matching sentinel error.
+// Errors already wrapping a sentinel, and unknown errors, are returned as is.
+func classifyError(err error) error {
+	for _, sentinel := range sentinels {
+		if errors.Is(err, sentinel) {
+			return err
+		}
+	}
+
+	if sentinel := findSentinel(err); sentinel != nil {
+		return fmt.Errorf("%w: %w", sentinel, err)
+	}
+
+	return err
+}
+
+func findSentinel(err error) error {
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrBackendUnavailable
+	}
+
+	if errors.Is(err, vault.ErrSecretNotFound) {
+		return ErrNotFound
+	}
+
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "ResourceNotFoundException", "ParameterNotFound", "ParameterVersionNotFound",
+			"NoSuchKey", "NoSuchBucket", "NoSuchVersion", "NotFound":
+			return ErrNotFound
+		case "AccessDenied", "AccessDeniedException", "UnauthorizedOperation",
+			"UnrecognizedClientException", "InvalidSignatureException",
+			"ExpiredToken", "ExpiredTokenException", "KMSAccessDeniedException":
+			return ErrAccessDenied
+		case "ThrottlingException", "Throttling", "TooManyRequestsException",
+			"ServiceUnavailable", "ServiceUnavailableException", "InternalServiceError",
+			"InternalServerError", "InternalFailure", "InternalError", "SlowDown":
+			return ErrBackendUnavailable
+		}
+	}
+
+	var respErr *smithyhttp.ResponseError
+	if errors.As(err, &respErr) {
+		if sentinel := statusSentinel(respErr.HTTPStatusCode()); sentinel != nil {
+			return sentinel
+		}
+	}
+
+	var vaultErr *vault.ResponseError
+	if errors.As(err, &vaultErr) {
+		if sentinel := statusSentinel(vaultErr.StatusCode); sentinel != nil {
+			return sentinel
+		}
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return ErrBackendUnavailable
+	}
+
+	return nil
+}
+
+// statusSentinel maps HTTP status to sentinel error.
+func statusSentinel(status int) error {
+	switch {
+	case status == http.StatusNotFound:
+		return ErrNotFound
+	case status == http.StatusUnauthorized, status == http.StatusForbidden:
+		return ErrAccessDenied
+	case status == http.StatusTooManyRequests, status >= 500:
+		return ErrBackendUnavailable
+	}
+	return nil
+}
+
+// statusError builds an error for unexpected HTTP status.
+func statusError(me, u string, status int, body string) error {
+	err := fmt.Errorf("%s: URL=%s bad status=%d: %v", me, u, status, body)
+	if sentinel := statusSentinel(status); sentinel != nil {
+		return fmt.Errorf("%w: %w", sentinel, err)
+	}
+	return err
+}
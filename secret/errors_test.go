@@ -0,0 +1,132 @@
+package secret
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/aws/smithy-go"
+	vault "github.com/hashicorp/vault/api"
+)
+
+type classifyTest struct {
+	testName string
+	err      error
+	expected error
+}
+
+var classifyTestTable = []classifyTest{
+	{"secrets manager not found", &smithy.GenericAPIError{Code: "ResourceNotFoundException"}, ErrNotFound},
+	{"parameter not found", &smithy.GenericAPIError{Code: "ParameterNotFound"}, ErrNotFound},
+	{"s3 no such key", &smithy.GenericAPIError{Code: "NoSuchKey"}, ErrNotFound},
+	{"access denied", &smithy.GenericAPIError{Code: "AccessDeniedException"}, ErrAccessDenied},
+	{"throttling", &smithy.GenericAPIError{Code: "ThrottlingException"}, ErrBackendUnavailable},
+	{"vault not found", fmt.Errorf("%w: at secret/data/x", vault.ErrSecretNotFound), ErrNotFound},
+	{"vault forbidden", &vault.ResponseError{StatusCode: http.StatusForbidden}, ErrAccessDenied},
+	{"deadline", context.DeadlineExceeded, ErrBackendUnavailable},
+	{"already classified", fmt.Errorf("%w: bad", ErrMalformedReference), ErrMalformedReference},
+	{"unknown", errors.New("unknown"), nil},
+}
+
+func TestClassifyError(t *testing.T) {
+
+	for i, data := range classifyTestTable {
+		err := classifyError(data.err)
+
+		if !errors.Is(err, data.err) {
+			t.Errorf("%d/%d: %s: original error lost: %v",
+				i+1, len(classifyTestTable), data.testName, err)
+		}
+
+		for _, sentinel := range sentinels {
+			if got, want := errors.Is(err, sentinel), sentinel == data.expected; got != want {
+				t.Errorf("%d/%d: %s: errors.Is(%v)=%t expected=%t: %v",
+					i+1, len(classifyTestTable), data.testName, sentinel, got, want, err)
+			}
+		}
+	}
+}
+
+func TestQueryError(t *testing.T) {
+
+	secret := New(Options{AwsConfigSource: &AwsConfigSource{}})
+
+	secret.RegisterBackend("denied-store", BackendFunc(func(_ context.Context, _ Query) (string, error) {
+		return "", &smithy.GenericAPIError{Code: "AccessDeniedException"}
+	}))
+
+	_, err := secret.RetrieveWithError("denied-store:us-east-1:db")
+
+	if !errors.Is(err, ErrAccessDenied) {
+		t.Errorf("expected access denied, got: %v", err)
+	}
+
+	var queryErr *QueryError
+	if !errors.As(err, &queryErr) {
+		t.Fatalf("expected QueryError, got: %T: %v", err, err)
+	}
+	if queryErr.Prefix != "denied-store" || queryErr.Region != "us-east-1" {
+		t.Errorf("query error: prefix=%s region=%s", queryErr.Prefix, queryErr.Region)
+	}
+}
+
+func TestFieldMissing(t *testing.T) {
+
+	secret := New(Options{AwsConfigSource: &AwsConfigSource{}})
+
+	secret.RegisterBackend("store", BackendFunc(func(_ context.Context, _ Query) (string, error) {
+		return `{"uri":"mongodb://store"}`, nil
+	}))
+
+	if _, err := secret.RetrieveWithError("store::db:missing"); !errors.Is(err, ErrFieldMissing) {
+		t.Errorf("expected field missing, got: %v", err)
+	}
+}
+
+func TestStrict(t *testing.T) {
+
+	const malformed = "aws-secretsmanager"
+
+	lenient := New(Options{AwsConfigSource: &AwsConfigSource{}})
+
+	value, err := lenient.RetrieveWithError(malformed)
+	if err != nil || value != malformed {
+		t.Errorf("lenient: expected pass-through, got value=%s: %v", value, err)
+	}
+
+	strict := New(Options{AwsConfigSource: &AwsConfigSource{}, Strict: true})
+
+	if _, err := strict.RetrieveWithError(malformed); !errors.Is(err, ErrMalformedReference) {
+		t.Errorf("strict: expected malformed reference, got: %v", err)
+	}
+
+	// literal values without known prefix are not affected
+	if value, err := strict.RetrieveWithError("http://real-db"); err != nil || value != "http://real-db" {
+		t.Errorf("strict: expected literal, got value=%s: %v", value, err)
+	}
+}
+
+func TestHTTPNotFound(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.NotFound(w, nil)
+	}))
+	defer ts.Close()
+
+	u, errURL := url.Parse(ts.URL)
+	if errURL != nil {
+		t.Fatalf("url: %v", errURL)
+	}
+
+	name := fmt.Sprintf("#http::GET,http,%s,%s,/,text/plain,,", u.Hostname(), u.Port())
+
+	secret := New(Options{AwsConfigSource: &AwsConfigSource{}})
+
+	if _, err := secret.RetrieveWithError(name); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected not found, got: %v", err)
+	}
+}
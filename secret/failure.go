@@ -0,0 +1,93 @@
+package secret
+
+import (
+	"fmt"
+	"os"
+)
+
+// FailurePolicy defines what Retrieve does when a secret cannot be retrieved.
+type FailurePolicy int
+
+// Failure policies for Options.FailurePolicy.
+const (
+	// FailReturnName returns the name itself, as if it were a literal value.
+	// If CrashOnQueryError is set, it exits the process instead.
+	// This is the default policy, kept for compatibility.
+	// Beware the name may embed credentials, like a Vault token or a bearer token.
+	FailReturnName FailurePolicy = iota
+
+	// FailReturnEmpty returns the empty string.
+	// envconfig then falls back to the default value.
+	FailReturnEmpty
+
+	// FailReturnDefault returns Options.FailureDefault.
+	FailReturnDefault
+
+	// FailPanic panics with *RetrieveError, so that deferred
+	// functions run and the caller may recover.
+	FailPanic
+
+	// FailHook returns the value returned by Options.OnError.
+	FailHook
+)
+
+// ErrorHandler is invoked on error for FailHook policy.
+// It returns the value Retrieve should return.
+type ErrorHandler func(name string, err error) string
+
+// String returns the policy name.
+func (p FailurePolicy) String() string {
+	switch p {
+	case FailReturnName:
+		return "return-name"
+	case FailReturnEmpty:
+		return "return-empty"
+	case FailReturnDefault:
+		return "return-default"
+	case FailPanic:
+		return "panic"
+	case FailHook:
+		return "hook"
+	}
+	return fmt.Sprintf("FailurePolicy(%d)", int(p))
+}
+
+// RetrieveError is the panic value for FailPanic.
+// Its message is the message from Err, which may quote parts of the
+// name, hence it is not safe to expose when the name embeds credentials.
+type RetrieveError struct {
+	Name string
+	Err  error
+}
+
+func (e *RetrieveError) Error() string {
+	return fmt.Sprintf("secret retrieve error: %v", e.Err)
+}
+
+func (e *RetrieveError) Unwrap() error {
+	return e.Err
+}
+
+// fail applies the failure policy.
+func (s *Secret) fail(name string, err error) string {
+	const me = "Secret.fail"
+
+	switch s.options.FailurePolicy {
+	case FailReturnEmpty:
+		return ""
+	case FailReturnDefault:
+		return s.options.FailureDefault
+	case FailPanic:
+		panic(&RetrieveError{Name: name, Err: err})
+	case FailHook:
+		return s.options.OnError(name, err)
+	}
+
+	if s.options.CrashOnQueryError {
+		s.options.Printf("%s: error: crashing on error: name='%s': %v",
+			me, name, err)
+		os.Exit(1)
+	}
+
+	return name
+}
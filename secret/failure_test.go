@@ -0,0 +1,74 @@
+package secret
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func newFailingSecret(opt Options) *Secret {
+	opt.AwsConfigSource = &AwsConfigSource{}
+	s := New(opt)
+	s.RegisterBackend("failing-store", BackendFunc(func(_ context.Context, _ Query) (string, error) {
+		return "", errors.New("store is down")
+	}))
+	return s
+}
+
+const failingName = "failing-store::token,credential:uri"
+
+func TestFailurePolicy(t *testing.T) {
+
+	var hookName string
+
+	type policyTest struct {
+		options  Options
+		expected string
+	}
+
+	table := []policyTest{
+		{Options{}, failingName},
+		{Options{FailurePolicy: FailReturnEmpty}, ""},
+		{Options{FailurePolicy: FailReturnDefault, FailureDefault: "fallback"}, "fallback"},
+		{Options{FailurePolicy: FailHook, OnError: func(name string, _ error) string {
+			hookName = name
+			return "from-hook"
+		}}, "from-hook"},
+	}
+
+	for _, data := range table {
+		s := newFailingSecret(data.options)
+		if value := s.Retrieve(failingName); value != data.expected {
+			t.Errorf("policy=%s: expected=%s got=%s",
+				data.options.FailurePolicy, data.expected, value)
+		}
+	}
+
+	if hookName != failingName {
+		t.Errorf("hook name: expected=%s got=%s", failingName, hookName)
+	}
+}
+
+func TestFailurePolicyPanic(t *testing.T) {
+
+	s := newFailingSecret(Options{FailurePolicy: FailPanic})
+
+	defer func() {
+		r := recover()
+		retrieveErr, isRetrieveErr := r.(*RetrieveError)
+		if !isRetrieveErr {
+			t.Fatalf("expected *RetrieveError panic, got: %T: %v", r, r)
+		}
+		if retrieveErr.Name != failingName {
+			t.Errorf("name: expected=%s got=%s", failingName, retrieveErr.Name)
+		}
+		var queryErr *QueryError
+		if !errors.As(retrieveErr, &queryErr) {
+			t.Errorf("expected wrapped QueryError: %v", retrieveErr)
+		}
+	}()
+
+	s.Retrieve(failingName)
+
+	t.Errorf("expected panic")
+}
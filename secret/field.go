@@ -0,0 +1,313 @@
+package secret
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+extractField extracts a field from a secret in JSON or YAML.
+
+The field is a path into the document:
+
+	uri                    top-level key
+	db.primary.uri         nested keys
+	hosts[0]               array index
+	hosts.0                array index
+	$.db.hosts[1].port     optional JSONPath-like root
+	["key.with.dots"].uri  quoted key
+
+A top-level key matching the whole field takes precedence, hence keys
+containing dots keep working as plain field names.
+
+Scalars are returned with their original text, so 1.0 stays "1.0";
+sub-objects and arrays are re-encoded as JSON.
+The boolean result reports whether the field was found.
+*/
+func extractField(secretString, field string) (string, bool, error) {
+
+	doc, errDecode := decodeNode(secretString)
+	if errDecode != nil {
+		return "", false, errDecode
+	}
+
+	// top-level key matching the whole field
+	if v, found := nodeChild(doc, field); found && doc.Kind == yaml.MappingNode {
+		str, err := nodeString(v)
+		return str, true, err
+	}
+
+	path, errPath := parseFieldPath(field)
+	if errPath != nil {
+		return "", false, errPath
+	}
+
+	v := doc
+
+	for _, elem := range path {
+		child, found := nodeChild(v, elem)
+		if !found {
+			return "", false, nil
+		}
+		v = child
+	}
+
+	str, err := nodeString(v)
+	return str, true, err
+}
+
+// decodeNode parses JSON or YAML into a node tree, which keeps the
+// original text of scalars: 1.0 stays "1.0" rather than float 1.
+// An empty document is a null node.
+func decodeNode(secretString string) (*yaml.Node, error) {
+	var doc yaml.Node
+
+	if err := yaml.Unmarshal([]byte(secretString), &doc); err != nil {
+		return nil, err
+	}
+
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null"}, nil
+	}
+
+	return resolveAlias(doc.Content[0]), nil
+}
+
+// resolveAlias follows YAML aliases to the anchored node.
+func resolveAlias(n *yaml.Node) *yaml.Node {
+	for n.Kind == yaml.AliasNode && n.Alias != nil {
+		n = n.Alias
+	}
+	return n
+}
+
+// nodeChild finds a mapping key, or an array index.
+func nodeChild(n *yaml.Node, elem string) (*yaml.Node, bool) {
+	switch n.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if resolveAlias(n.Content[i]).Value == elem {
+				return resolveAlias(n.Content[i+1]), true
+			}
+		}
+		// YAML merge keys: <<: *base
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if n.Content[i].Tag != "!!merge" {
+				continue
+			}
+			merged := resolveAlias(n.Content[i+1])
+			sources := []*yaml.Node{merged}
+			if merged.Kind == yaml.SequenceNode {
+				sources = merged.Content
+			}
+			for _, src := range sources {
+				if child, found := nodeChild(resolveAlias(src), elem); found {
+					return child, true
+				}
+			}
+		}
+	case yaml.SequenceNode:
+		i, errIndex := strconv.Atoi(elem)
+		if errIndex != nil || i < 0 || i >= len(n.Content) {
+			return nil, false
+		}
+		return resolveAlias(n.Content[i]), true
+	}
+	return nil, false
+}
+
+// nodeString renders scalars as their original text and other values as JSON.
+func nodeString(n *yaml.Node) (string, error) {
+	if n.Kind == yaml.ScalarNode {
+		if n.Tag == "!!null" {
+			return "", nil
+		}
+		return n.Value, nil
+	}
+	v, err := nodeValue(n)
+	if err != nil {
+		return "", err
+	}
+	return fieldString(v)
+}
+
+// nodeValue converts a node tree into values for JSON encoding,
+// keeping the original text of numbers valid in JSON.
+func nodeValue(n *yaml.Node) (any, error) {
+	n = resolveAlias(n)
+
+	switch n.Kind {
+	case yaml.MappingNode:
+		m := make(map[string]any, len(n.Content)/2)
+		var merged []map[string]any
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			v, err := nodeValue(n.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			if n.Content[i].Tag == "!!merge" {
+				// YAML merge keys: <<: *base
+				switch mv := v.(type) {
+				case map[string]any:
+					merged = append(merged, mv)
+				case []any:
+					for _, item := range mv {
+						if im, isMap := item.(map[string]any); isMap {
+							merged = append(merged, im)
+						}
+					}
+				}
+				continue
+			}
+			m[resolveAlias(n.Content[i]).Value] = v
+		}
+		for _, base := range merged {
+			for k, v := range base {
+				if _, found := m[k]; !found {
+					m[k] = v
+				}
+			}
+		}
+		return m, nil
+	case yaml.SequenceNode:
+		list := make([]any, 0, len(n.Content))
+		for _, child := range n.Content {
+			v, err := nodeValue(child)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	}
+
+	switch n.Tag {
+	case "!!str":
+		return n.Value, nil
+	case "!!int", "!!float":
+		if json.Valid([]byte(n.Value)) {
+			return json.Number(n.Value), nil
+		}
+	}
+
+	var v any
+	err := n.Decode(&v)
+	return normalize(v), err
+}
+
+// parseFieldPath splits field path into keys and array indexes.
+func parseFieldPath(field string) ([]string, error) {
+
+	p := strings.TrimPrefix(field, "$")
+
+	var path []string
+
+	for p != "" {
+		switch p[0] {
+		case '.':
+			p = p[1:]
+		case '[':
+			p = p[1:]
+			if p != "" && (p[0] == '"' || p[0] == '\'') {
+				quote := p[0]
+				end := strings.IndexByte(p[1:], quote)
+				if end < 0 || len(p) < end+3 || p[end+2] != ']' {
+					return nil, fmt.Errorf("bad field path, unterminated quoted key: '%s'", field)
+				}
+				path = append(path, p[1:end+1])
+				p = p[end+3:]
+				continue
+			}
+			end := strings.IndexByte(p, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("bad field path, missing ']': '%s'", field)
+			}
+			index := strings.TrimSpace(p[:end])
+			if _, errIndex := strconv.Atoi(index); errIndex != nil {
+				return nil, fmt.Errorf("bad field path, invalid index '%s': '%s'", index, field)
+			}
+			path = append(path, index)
+			p = p[end+1:]
+		default:
+			end := strings.IndexAny(p, ".[")
+			if end < 0 {
+				end = len(p)
+			}
+			path = append(path, p[:end])
+			p = p[end:]
+		}
+	}
+
+	if len(path) == 0 {
+		return nil, fmt.Errorf("bad field path, empty: '%s'", field)
+	}
+
+	return path, nil
+}
+
+// normalize converts YAML maps with non-string keys into map[string]any,
+// so that they can be walked and encoded as JSON.
+func normalize(v any) any {
+	switch node := v.(type) {
+	case map[any]any:
+		m := make(map[string]any, len(node))
+		for k, child := range node {
+			m[fmt.Sprint(k)] = normalize(child)
+		}
+		return m
+	case map[string]any:
+		for k, child := range node {
+			node[k] = normalize(child)
+		}
+		return node
+	case []any:
+		for i, child := range node {
+			node[i] = normalize(child)
+		}
+		return node
+	}
+	return v
+}
+
+// decodeMap decodes a JSON or YAML object into a map of strings.
+func decodeMap(secretString string) (map[string]string, error) {
+	doc, errDecode := decodeNode(secretString)
+	if errDecode != nil {
+		return nil, errDecode
+	}
+
+	if doc.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("secret is not an object: %s", doc.Tag)
+	}
+
+	m := make(map[string]string, len(doc.Content)/2)
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		str, err := nodeString(resolveAlias(doc.Content[i+1]))
+		if err != nil {
+			return nil, err
+		}
+		m[resolveAlias(doc.Content[i]).Value] = str
+	}
+
+	return m, nil
+}
+
+// fieldString renders scalars as strings and other values as JSON.
+func fieldString(v any) (string, error) {
+	switch value := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	case json.Number:
+		return value.String(), nil
+	case map[string]any, []any:
+		data, err := json.Marshal(value)
+		return string(data), err
+	}
+	return fmt.Sprint(v), nil
+}
@@ -0,0 +1,72 @@
+package secret
+
+import "testing"
+
+type fieldTest struct {
+	testName    string
+	secret      string
+	field       string
+	expectValue string
+	expectFound bool
+	expectError bool
+}
+
+const nestedSecret = `{
+	"uri": "mongodb://flat",
+	"a.b": "dotted",
+	"db": {"primary": {"uri": "mongodb://primary", "port": 27017}, "tls": true},
+	"hosts": ["h0", {"name": "h1", "port": 8080}],
+	"empty": null
+}`
+
+var fieldTestTable = []fieldTest{
+	{"flat", nestedSecret, "uri", "mongodb://flat", true, expectOk},
+	{"dotted key", nestedSecret, "a.b", "dotted", true, expectOk},
+	{"nested", nestedSecret, "db.primary.uri", "mongodb://primary", true, expectOk},
+	{"number", nestedSecret, "db.primary.port", "27017", true, expectOk},
+	{"bool", nestedSecret, "db.tls", "true", true, expectOk},
+	{"object", nestedSecret, "db.primary", `{"port":27017,"uri":"mongodb://primary"}`, true, expectOk},
+	{"array", nestedSecret, "hosts", `["h0",{"name":"h1","port":8080}]`, true, expectOk},
+	{"index", nestedSecret, "hosts[0]", "h0", true, expectOk},
+	{"index dotted", nestedSecret, "hosts.1.name", "h1", true, expectOk},
+	{"jsonpath", nestedSecret, "$.hosts[1].port", "8080", true, expectOk},
+	{"quoted", nestedSecret, `["a.b"]`, "dotted", true, expectOk},
+	{"null", nestedSecret, "empty", "", true, expectOk},
+	{"missing", nestedSecret, "db.secondary.uri", "", false, expectOk},
+	{"index out of range", nestedSecret, "hosts[5]", "", false, expectOk},
+	{"bad index", nestedSecret, "hosts[x]", "", false, expectError},
+	{"unterminated", nestedSecret, `hosts["x`, "", false, expectError},
+	{"yaml", "db:\n  uri: mongodb://yaml\n", "db.uri", "mongodb://yaml", true, expectOk},
+	{"number text", `{"v":1.0}`, "v", "1.0", true, expectOk},
+	{"number leading zero", `{"v":0.10}`, "v", "0.10", true, expectOk},
+	{"number in object", `{"o":{"v":1.0}}`, "o", `{"v":1.0}`, true, expectOk},
+	{"yaml anchor", "a: &x {u: 1}\nb: *x\n", "b.u", "1", true, expectOk},
+	{"yaml merge", "a: &x {u: 1}\nb:\n  <<: *x\n  v: 2\n", "b.u", "1", true, expectOk},
+	{"yaml merge object", "a: &x {u: 1}\nb:\n  <<: *x\n  v: 2\n", "b", `{"u":1,"v":2}`, true, expectOk},
+	{"bad json", "{", "uri", "", false, expectError},
+}
+
+func TestExtractField(t *testing.T) {
+
+	for i, data := range fieldTestTable {
+		value, found, err := extractField(data.secret, data.field)
+
+		isError := err != nil
+
+		if isError != data.expectError {
+			t.Errorf("%d/%d: %s: unexpected error: got=%t expected=%t: %v",
+				i+1, len(fieldTestTable), data.testName, isError, data.expectError, err)
+			continue
+		}
+
+		if found != data.expectFound {
+			t.Errorf("%d/%d: %s: found error: got=%t expected=%t",
+				i+1, len(fieldTestTable), data.testName, found, data.expectFound)
+		}
+
+		if value != data.expectValue {
+			t.Errorf("%d/%d: %s: value error: got=%s expected=%s",
+				i+1, len(fieldTestTable), data.testName, value, data.expectValue)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package secret
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+//
+// Structured files are converted into JSON objects, so that fields are
+// extracted exactly as for JSON secrets:
+//
+//     .env         DB_URI=mongodb://db      => {"DB_URI":"mongodb://db"}
+//     .properties  db.uri=mongodb://db      => {"db.uri":"mongodb://db"}
+//     .ini         [db] uri=mongodb://db    => {"db":{"uri":"mongodb://db"}}
+//     .toml        [db] uri="mongodb://db"  => {"db":{"uri":"mongodb://db"}}
+//
+
+// formats lists supported structured formats.
+var formats = []string{"raw", "env", "properties", "ini", "toml"}
+
+// detectFormat guesses format from file extension.
+// Unknown extensions, including JSON and YAML, are kept raw.
+func detectFormat(filename string) string {
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".env":
+		return "env"
+	case ".properties":
+		return "properties"
+	case ".ini":
+		return "ini"
+	case ".toml":
+		return "toml"
+	}
+	return "raw"
+}
+
+// convertFormat parses a structured file into a JSON object.
+func convertFormat(format, data string) (string, error) {
+	var obj any
+	var err error
+
+	switch format {
+	case "", "raw":
+		return data, nil
+	case "env":
+		obj, err = parseEnv(data)
+	case "properties":
+		obj, err = parseProperties(data)
+	case "ini":
+		obj, err = parseINI(data)
+	case "toml":
+		var m map[string]any
+		err = toml.Unmarshal([]byte(data), &m)
+		obj = m
+	default:
+		return "", fmt.Errorf("unknown format '%s', expecting one of %v", format, formats)
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("parse %s: %w", format, err)
+	}
+
+	j, errJSON := json.Marshal(obj)
+	return string(j), errJSON
+}
+
+// parseEnv parses dotenv files:
+//
+//	# comment
+//	export KEY=value
+//	KEY="double quoted\nwith escapes"
+//	KEY='single quoted'
+func parseEnv(data string) (map[string]string, error) {
+	m := map[string]string{}
+
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, found := strings.Cut(line, "=")
+		if !found {
+			return nil, fmt.Errorf("line %d: expecting KEY=value", lineNum)
+		}
+
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		switch {
+		case len(value) > 1 && value[0] == '"':
+			end := closingQuote(value)
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated double quote", lineNum)
+			}
+			unquoted, err := strconv.Unquote(value[:end+1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNum, err)
+			}
+			value = unquoted
+		case len(value) > 1 && value[0] == '\'':
+			end := strings.IndexByte(value[1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated single quote", lineNum)
+			}
+			value = value[1 : end+1]
+		default:
+			// unquoted values end at inline comment
+			if i := strings.Index(value, " #"); i >= 0 {
+				value = strings.TrimSpace(value[:i])
+			}
+		}
+
+		m[key] = value
+	}
+
+	return m, scanner.Err()
+}
+
+// closingQuote finds the closing double quote, skipping escaped quotes.
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
+
+// parseProperties parses Java properties files:
+//
+//	# comment
+//	! comment
+//	key=value
+//	key: value
+//	key value
+//	long.key=first \
+//	         second
+func parseProperties(data string) (map[string]string, error) {
+	m := map[string]string{}
+
+	var logical strings.Builder
+
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimLeft(scanner.Text(), " \t\f")
+		if logical.Len() == 0 && (line == "" || line[0] == '#' || line[0] == '!') {
+			continue
+		}
+
+		if continued, trimmed := propertiesContinued(line); continued {
+			logical.WriteString(trimmed)
+			continue
+		}
+
+		logical.WriteString(line)
+		key, value := splitProperty(logical.String())
+		m[key] = value
+		logical.Reset()
+	}
+
+	if logical.Len() > 0 {
+		key, value := splitProperty(logical.String())
+		m[key] = value
+	}
+
+	return m, scanner.Err()
+}
+
+// propertiesContinued reports whether line ends with an odd number of backslashes.
+func propertiesContinued(line string) (bool, string) {
+	n := 0
+	for i := len(line) - 1; i >= 0 && line[i] == '\\'; i-- {
+		n++
+	}
+	if n%2 == 1 {
+		return true, line[:len(line)-1]
+	}
+	return false, line
+}
+
+// splitProperty splits key from value at the first unescaped '=', ':' or whitespace.
+func splitProperty(line string) (string, string) {
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '=', ':', ' ', '\t', '\f':
+			key := line[:i]
+			rest := strings.TrimLeft(line[i:], " \t\f")
+			if rest != "" && (rest[0] == '=' || rest[0] == ':') {
+				rest = strings.TrimLeft(rest[1:], " \t\f")
+			}
+			return unescapeProperty(key), unescapeProperty(rest)
+		}
+	}
+	return unescapeProperty(line), ""
+}
+
+func unescapeProperty(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' || i == len(s)-1 {
+			sb.WriteByte(c)
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			sb.WriteByte('\n')
+		case 't':
+			sb.WriteByte('\t')
+		case 'r':
+			sb.WriteByte('\r')
+		case 'f':
+			sb.WriteByte('\f')
+		case 'u':
+			if i+4 < len(s) {
+				if r, err := strconv.ParseUint(s[i+1:i+5], 16, 32); err == nil {
+					sb.WriteRune(rune(r))
+					i += 4
+					continue
+				}
+			}
+			sb.WriteByte('u')
+		default:
+			sb.WriteByte(s[i])
+		}
+	}
+	return sb.String()
+}
+
+// parseINI parses INI files. Keys before the first section are top-level,
+// keys within sections are nested under the section name:
+//
+//	; comment
+//	name=app
+//	[db]
+//	uri = "mongodb://db"
+func parseINI(data string) (map[string]any, error) {
+	m := map[string]any{}
+	current := m
+
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == ';' || line[0] == '#' {
+			continue
+		}
+
+		if line[0] == '[' {
+			end := strings.IndexByte(line, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated section", lineNum)
+			}
+			name := strings.TrimSpace(line[1:end])
+			section, isMap := m[name].(map[string]any)
+			if !isMap {
+				section = map[string]any{}
+				m[name] = section
+			}
+			current = section
+			continue
+		}
+
+		i := strings.IndexAny(line, "=:")
+		if i < 0 {
+			return nil, fmt.Errorf("line %d: expecting key=value", lineNum)
+		}
+		key, value := line[:i], strings.TrimSpace(line[i+1:])
+
+		if len(value) > 1 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+
+		current[strings.TrimSpace(key)] = value
+	}
+
+	return m, scanner.Err()
+}
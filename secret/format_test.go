@@ -0,0 +1,83 @@
+package secret
+
+import (
+	"testing"
+)
+
+type formatTestCase struct {
+	name          string
+	format        string
+	data          string
+	field         string
+	expectedError bool
+	expected      string
+}
+
+var formatTestTable = []formatTestCase{
+	{"raw", "raw", "text", "", expectOk, "text"},
+	{"env", "env", "# comment\nexport DB_URI=mongodb://db\nUSER=admin # inline\n", "DB_URI", expectOk, "mongodb://db"},
+	{"env inline comment", "env", "USER=admin # inline\n", "USER", expectOk, "admin"},
+	{"env double quote", "env", `PASS="a\"b\nc" # comment`, "PASS", expectOk, "a\"b\nc"},
+	{"env single quote", "env", `PASS='a\nb'`, "PASS", expectOk, `a\nb`},
+	{"env unterminated", "env", `PASS="abc`, "PASS", expectError, ""},
+	{"env bad line", "env", "PASS", "PASS", expectError, ""},
+	{"properties equals", "properties", "! comment\ndb.uri=mongodb://db\n", "db.uri", expectOk, "mongodb://db"},
+	{"properties colon", "properties", "db.user : admin", "db.user", expectOk, "admin"},
+	{"properties space", "properties", "db.user admin", "db.user", expectOk, "admin"},
+	{"properties continuation", "properties", "list=a,\\\n    b", "list", expectOk, "a,b"},
+	{"properties escapes", "properties", `key\=x=café\tz`, "key=x", expectOk, "café\tz"},
+	{"ini top-level", "ini", "name=app\n[db]\nuri = \"mongodb://db\"\n", "name", expectOk, "app"},
+	{"ini section", "ini", "; comment\n[db]\nuri = \"mongodb://db\"\n", "db.uri", expectOk, "mongodb://db"},
+	{"ini colon", "ini", "[db]\nuri: mongodb://db?a=b\n", "db.uri", expectOk, "mongodb://db?a=b"},
+	{"ini unterminated", "ini", "[db\n", "", expectError, ""},
+	{"toml", "toml", "[db]\nuri = \"mongodb://db\"\nport = 27017\n", "db.port", expectOk, "27017"},
+	{"toml bad", "toml", "[db\n", "", expectError, ""},
+	{"unknown", "xml", "<a/>", "", expectError, ""},
+}
+
+func TestConvertFormat(t *testing.T) {
+	for _, data := range formatTestTable {
+		t.Run(data.name, func(t *testing.T) {
+			value, err := convertFormat(data.format, data.data)
+			if data.expectedError {
+				if err == nil {
+					t.Errorf("expected error, got: %s", value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if data.field != "" {
+				var found bool
+				value, found, err = extractField(value, data.field)
+				if err != nil || !found {
+					t.Fatalf("field %s: found=%t error: %v", data.field, found, err)
+				}
+			}
+			if value != data.expected {
+				t.Errorf("expected=%q got=%q", data.expected, value)
+			}
+		})
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	table := map[string]string{
+		"app/prod.env":           "env",
+		".env":                   "env",
+		"app/app.properties":     "properties",
+		"app/config.INI":         "ini",
+		"app/config.toml":        "toml",
+		"app/config.yaml":        "raw",
+		"app/config.json":        "raw",
+		"app/no-extension":       "raw",
+		"app.toml/no-extension":  "raw",
+		"app/archive.env.backup": "raw",
+	}
+	for filename, expected := range table {
+		if format := detectFormat(filename); format != expected {
+			t.Errorf("filename=%s expected=%s got=%s", filename, expected, format)
+		}
+	}
+}
@@ -2,14 +2,20 @@ package secret
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"os"
+	"slices"
+	"strconv"
 	"strings"
-
-	"github.com/udhos/boilerplate/boilerplate"
+	"time"
 )
 
 /*
@@ -23,76 +29,475 @@ export DB_URI=#http::GET,https,tttt.lambda-url.us-east-1.on.aws,443,/,text/plain
 #         Body: {"parameter":"mongodb"} (base64 encoded as eyJwYXJhbWV0ZXIiOiJtb25nb2RiIn0=)
 #        Token: Bearer secret
 #     Response: {"uri":"mongodb://127.0.0.1:27001/?retryWrites=false"}
+
+export DB_URI='secret+http://tttt.lambda-url.us-east-1.on.aws:443/?content-type=text/plain&body=%7B%22parameter%22%3A%22mongodb%22%7D&token=Bearer+secret&field=uri'
+# Options: method (default GET), proto (default https), content-type, body (plain text), token
+
+Further options, appended to legacy syntax as ,name=value or given as URI query parameters:
+
+	header=Name=value       request header, repeatable
+	query=name=value        URL query parameter, repeatable
+	status=200-299          accepted status code or range, repeatable, defaults to 200
+	timeout=10s             request timeout, defaults to 30s
+	retries=2               retries on connection errors and 5xx status, defaults to 2 for GET, HEAD
+	                        and OPTIONS, and to 0 for other methods, like POST, that may not be idempotent
+	retry-delay=100ms       delay before first retry, doubled on each retry, defaults to 100ms
+	ca=/path/ca.pem         PEM CA bundle trusted besides system roots
+	client-cert=/path/cert  PEM client certificate for mTLS
+	client-key=/path/key    PEM client key for mTLS
+	auth=oauth2             OAuth2 client credentials, see oauth2.go
+	auth=sigv4              AWS SigV4 request signing, see sigv4.go
+
+export DB_URI=#http::GET,https,config.internal,8443,/db,,,,header=X-Api-Key=secret,ca=/etc/ssl/internal-ca.pem:uri
 */
-func queryHTTP(_ /*debug*/ bool, _ /*printf*/ boilerplate.FuncPrintf, _ /*unused*/ AwsConfigSolver, httpOptions string) (string, error) {
+func queryHTTP(ctx context.Context, q Query) (string, error) {
 	const me = "queryHTTP"
 
-	const minFields = 8
-	options := strings.SplitN(httpOptions, ",", minFields)
-	if len(options) < minFields {
-		return "", fmt.Errorf("%s: bad http options, expecting %d fields - got: '%s'",
-			me, minFields, httpOptions)
+	opt, errParse := parseHTTPOptions(q)
+	if errParse != nil {
+		return "", fmt.Errorf("%w: %s: %w", ErrMalformedReference, me, errParse)
 	}
 
-	for i, o := range options {
-		options[i] = strings.TrimSpace(o)
+	client, errClient := q.httpClient(opt.tls)
+	if errClient != nil {
+		return "", fmt.Errorf("%w: %s: %w", ErrMalformedReference, me, errClient)
 	}
 
-	method := options[0]
-	proto := options[1]
-	host := options[2]
-	port := options[3]
-	path := options[4]
-	contentType := options[5]
-	body := options[6]
-	token := options[7]
+	ctxTimeout, cancel := context.WithTimeout(ctx, opt.timeout)
+	defer cancel()
+
+	authorization := opt.token
 
-	if port != "" {
-		host += ":" + port
+	if opt.oauth2 != nil {
+		var errAuth error
+		authorization, errAuth = opt.oauth2.authorization(ctxTimeout, q, client, "")
+		if errAuth != nil {
+			return "", errAuth
+		}
 	}
 
-	u, errJoin := url.JoinPath(proto+"://"+host, path)
-	if errJoin != nil {
-		return "", errJoin
+	var sign func(req *http.Request) error
+	if opt.sigv4 != nil {
+		sign = func(req *http.Request) error {
+			return opt.sigv4.sign(ctxTimeout, q, req, opt.body)
+		}
 	}
 
-	bodyPlain, errBody := base64.StdEncoding.DecodeString(body)
-	if errBody != nil {
-		return "", errBody
+	delay := opt.retryDelay
+	refreshed := false
+
+	for attempt := 0; ; attempt++ {
+		str, status, errDo := doHTTP(ctxTimeout, client, opt, authorization, sign)
+
+		if status == http.StatusUnauthorized && opt.oauth2 != nil && !refreshed {
+			// token revoked or expired early: get a new one and try again
+			refreshed = true
+			var errAuth error
+			authorization, errAuth = opt.oauth2.authorization(ctxTimeout, q, client, authorization)
+			if errAuth != nil {
+				return "", errAuth
+			}
+			attempt--
+			continue
+		}
+
+		retry := attempt < opt.retries && ctxTimeout.Err() == nil &&
+			(errDo != nil || status >= 500)
+
+		if !retry {
+			if errDo != nil {
+				return "", errDo
+			}
+			if !opt.accepts(status) {
+				return "", statusError(me, opt.url, status, str)
+			}
+			return str, nil
+		}
+
+		if q.Debug {
+			q.Printf("DEBUG %s: URL=%s attempt=%d status=%d error=%v: retrying in %v",
+				me, opt.url, attempt+1, status, errDo, delay)
+		}
+
+		select {
+		case <-ctxTimeout.Done():
+			return "", ctxTimeout.Err()
+		case <-time.After(delay):
+		}
+
+		delay *= 2
 	}
+}
+
+// doHTTP sends a single request, returning the response body and status.
+// sign is called last, when all headers are set, if not nil.
+func doHTTP(ctx context.Context, client *http.Client, opt httpOptions,
+	authorization string, sign func(req *http.Request) error) (string, int, error) {
 
-	req, errReq := http.NewRequest(method, u, bytes.NewBuffer(bodyPlain))
+	req, errReq := http.NewRequestWithContext(ctx, opt.method, opt.url, bytes.NewReader(opt.body))
 	if errReq != nil {
-		return "", errReq
+		return "", 0, errReq
+	}
+
+	if opt.contentType != "" {
+		req.Header.Set("Content-Type", opt.contentType)
+	}
+
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
 	}
 
-	if contentType != "" {
-		req.Header.Set("Content-Type", contentType)
+	for name, values := range opt.header {
+		for _, v := range values {
+			req.Header.Add(name, v)
+		}
 	}
 
-	if token != "" {
-		req.Header.Set("Authorization", token)
+	if sign != nil {
+		if errSign := sign(req); errSign != nil {
+			return "", 0, errSign
+		}
 	}
 
-	client := http.DefaultClient
 	resp, errDo := client.Do(req)
 	if errDo != nil {
-		return "", errDo
+		return "", 0, errDo
 	}
 
 	defer resp.Body.Close()
 
 	respBody, errRead := io.ReadAll(resp.Body)
 	if errRead != nil {
-		return "", errRead
+		return "", resp.StatusCode, errRead
+	}
+
+	return string(respBody), resp.StatusCode, nil
+}
+
+type httpOptions struct {
+	method      string
+	url         string
+	contentType string
+	body        []byte
+	token       string
+	header      http.Header
+	status      [][2]int // accepted status ranges
+	timeout     time.Duration
+	retries     int
+	retryDelay  time.Duration
+	tls         httpTLS
+	oauth2      *oauth2Options // nil unless auth=oauth2
+	sigv4       *sigv4Options  // nil unless auth=sigv4
+}
+
+// httpTLS holds TLS options, also used as key for client cache.
+type httpTLS struct {
+	ca         string
+	clientCert string
+	clientKey  string
+}
+
+// httpOptionNames lists named options.
+var httpOptionNames = append([]string{"header", "query", "status", "timeout",
+	"retries", "retry-delay", "ca", "client-cert", "client-key"},
+	append(oauth2OptionNames, sigv4OptionNames...)...)
+
+// httpURIOptionNames lists options available in URI syntax only,
+// since legacy syntax has them as positional fields.
+var httpURIOptionNames = []string{"method", "proto", "content-type", "body", "token"}
+
+func parseHTTPOptions(q Query) (httpOptions, error) {
+
+	var opt httpOptions
+
+	var options url.Values
+	var u string
+
+	if ref := q.Reference; ref.URI {
+		options = ref.Options
+
+		for name := range options {
+			if !slices.Contains(httpOptionNames, name) && !slices.Contains(httpURIOptionNames, name) {
+				return opt, fmt.Errorf("unknown option '%s'", name)
+			}
+		}
+
+		var errJoin error
+		u, errJoin = url.JoinPath(ref.option("proto", "https")+"://"+ref.Host, ref.Location)
+		if errJoin != nil {
+			return opt, errJoin
+		}
+		opt.method = ref.option("method", http.MethodGet)
+		opt.contentType = options.Get("content-type")
+		opt.body = []byte(options.Get("body"))
+		opt.token = options.Get("token")
+	} else {
+		var httpOptions string
+		httpOptions, options = trailingOptions(q.Name, httpOptionNames)
+
+		const minFields = 8
+		fields := strings.SplitN(httpOptions, ",", minFields)
+		if len(fields) < minFields {
+			return opt, fmt.Errorf("bad http options, expecting %d fields - got: '%s'",
+				minFields, httpOptions)
+		}
+
+		for i, o := range fields {
+			fields[i] = strings.TrimSpace(o)
+		}
+
+		method := fields[0]
+		proto := fields[1]
+		host := fields[2]
+		port := fields[3]
+		path := fields[4]
+		contentType := fields[5]
+		body := fields[6]
+		token := fields[7]
+
+		if port != "" {
+			host += ":" + port
+		}
+
+		var errJoin error
+		u, errJoin = url.JoinPath(proto+"://"+host, path)
+		if errJoin != nil {
+			return opt, errJoin
+		}
+
+		bodyPlain, errBody := base64.StdEncoding.DecodeString(body)
+		if errBody != nil {
+			return opt, fmt.Errorf("body: %w", errBody)
+		}
+
+		opt.method = method
+		opt.contentType = contentType
+		opt.body = bodyPlain
+		opt.token = token
+	}
+
+	//
+	// named options
+	//
+
+	if params := options["query"]; len(params) > 0 {
+		parsed, errURL := url.Parse(u)
+		if errURL != nil {
+			return opt, errURL
+		}
+		query := parsed.Query()
+		for _, p := range params {
+			name, value, found := strings.Cut(p, "=")
+			if !found || name == "" {
+				return opt, fmt.Errorf("bad query option, expecting name=value - got: '%s'", p)
+			}
+			query.Add(name, value)
+		}
+		parsed.RawQuery = query.Encode()
+		u = parsed.String()
+	}
+	opt.url = u
+
+	for _, h := range options["header"] {
+		name, value, found := strings.Cut(h, "=")
+		name = strings.TrimSpace(name)
+		if !found || name == "" {
+			return opt, fmt.Errorf("bad header option, expecting Name=value - got: '%s'", h)
+		}
+		if opt.header == nil {
+			opt.header = http.Header{}
+		}
+		opt.header.Add(name, strings.TrimSpace(value))
+	}
+
+	for _, s := range options["status"] {
+		r, errStatus := parseStatusRange(s)
+		if errStatus != nil {
+			return opt, errStatus
+		}
+		opt.status = append(opt.status, r)
+	}
+	if len(opt.status) == 0 {
+		opt.status = [][2]int{{http.StatusOK, http.StatusOK}}
 	}
 
-	str := string(respBody)
+	var errDuration error
+
+	opt.timeout, errDuration = durationOption(options, "timeout", 30*time.Second)
+	if errDuration != nil {
+		return opt, errDuration
+	}
+
+	opt.retryDelay, errDuration = durationOption(options, "retry-delay", 100*time.Millisecond)
+	if errDuration != nil {
+		return opt, errDuration
+	}
+
+	switch strings.ToUpper(opt.method) {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		opt.retries = 2
+	default:
+		opt.retries = 0 // do not resend requests that may not be idempotent
+	}
+	if r := options.Get("retries"); r != "" {
+		retries, errRetries := strconv.Atoi(r)
+		if errRetries != nil || retries < 0 {
+			return opt, fmt.Errorf("bad retries option: '%s'", r)
+		}
+		opt.retries = retries
+	}
+
+	opt.tls = httpTLS{
+		ca:         options.Get("ca"),
+		clientCert: options.Get("client-cert"),
+		clientKey:  options.Get("client-key"),
+	}
+
+	if (opt.tls.clientCert == "") != (opt.tls.clientKey == "") {
+		return opt, errors.New("client-cert and client-key must be given together")
+	}
+
+	switch auth := options.Get("auth"); auth {
+	case "", "oauth2", "sigv4":
+		if auth != "" && opt.token != "" {
+			return opt, fmt.Errorf("token and auth=%s are mutually exclusive", auth)
+		}
+	default:
+		return opt, fmt.Errorf("unknown auth '%s', expecting oauth2|sigv4", auth)
+	}
+
+	oauth2, errOAuth2 := parseOAuth2Options(options)
+	if errOAuth2 != nil {
+		return opt, errOAuth2
+	}
+	opt.oauth2 = oauth2
+
+	sigv4, errSigV4 := parseSigV4Options(q, opt.url, options)
+	if errSigV4 != nil {
+		return opt, errSigV4
+	}
+	opt.sigv4 = sigv4
+
+	return opt, nil
+}
+
+// parseStatusRange parses status code 200 or range 200-299.
+func parseStatusRange(s string) ([2]int, error) {
+	first, last, isRange := strings.Cut(s, "-")
+	if !isRange {
+		last = first
+	}
+	begin, errBegin := strconv.Atoi(strings.TrimSpace(first))
+	end, errEnd := strconv.Atoi(strings.TrimSpace(last))
+	if errBegin != nil || errEnd != nil || begin < 100 || end > 599 || begin > end {
+		return [2]int{}, fmt.Errorf("bad status option, expecting code or range like 200-299 - got: '%s'", s)
+	}
+	return [2]int{begin, end}, nil
+}
+
+// durationOption parses a duration option like 10s.
+func durationOption(options url.Values, name string, defaultValue time.Duration) (time.Duration, error) {
+	v := options.Get(name)
+	if v == "" {
+		return defaultValue, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("bad %s option: '%s'", name, v)
+	}
+	return d, nil
+}
+
+// accepts reports whether the response status is accepted.
+func (o httpOptions) accepts(status int) bool {
+	for _, r := range o.status {
+		if status >= r[0] && status <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// httpClient returns a client for the TLS options, cached by the Secret
+// so that connections are reused. Queries built without a Secret get a
+// new client every time.
+func (q Query) httpClient(opt httpTLS) (*http.Client, error) {
+	if q.secret == nil {
+		return newHTTPClient(opt, false)
+	}
+	return q.secret.httpClient(opt)
+}
+
+func (s *Secret) httpClient(opt httpTLS) (*http.Client, error) {
+	if c, found := s.httpClients.Load(opt); found {
+		return c.(*http.Client), nil
+	}
+
+	client, err := newHTTPClient(opt, true)
+	if err != nil {
+		return nil, err
+	}
+
+	c, _ := s.httpClients.LoadOrStore(opt, client)
+	return c.(*http.Client), nil
+}
+
+// newHTTPClient creates a dedicated client for the TLS options.
+// Timeouts are enforced with the request context.
+func newHTTPClient(opt httpTLS, keepAlive bool) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DisableKeepAlives = !keepAlive
+
+	tlsConfig, errTLS := opt.config()
+	if errTLS != nil {
+		return nil, errTLS
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{Transport: transport}, nil
+}
+
+// closeHTTP discards cached clients and OAuth2 tokens, hence
+// CA files are read again and new tokens requested.
+func (s *Secret) closeHTTP() {
+	s.httpClients.Range(func(_, c any) bool {
+		c.(*http.Client).CloseIdleConnections()
+		return true
+	})
+	s.httpClients.Clear()
+	s.oauth2Tokens.Clear()
+}
+
+// config builds TLS configuration.
+func (o httpTLS) config() (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if o.ca != "" {
+		pem, errRead := os.ReadFile(o.ca)
+		if errRead != nil {
+			return nil, fmt.Errorf("read ca: %w", errRead)
+		}
+		pool, errPool := x509.SystemCertPool()
+		if errPool != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in ca file: %s", o.ca)
+		}
+		tlsConfig.RootCAs = pool
+	}
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("%s: URL=%s bad status=%d: %v",
-			me, u, resp.StatusCode, str)
+	if o.clientCert != "" {
+		// fail early on bad files
+		if _, errCert := tls.LoadX509KeyPair(o.clientCert, o.clientKey); errCert != nil {
+			return nil, fmt.Errorf("load client certificate: %w", errCert)
+		}
+		// reload on every handshake, to pick up rotated certificates
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := tls.LoadX509KeyPair(o.clientCert, o.clientKey)
+			return &cert, err
+		}
 	}
 
-	return str, nil
+	return tlsConfig, nil
 }
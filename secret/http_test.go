@@ -1,13 +1,26 @@
 package secret
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"log"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/udhos/boilerplate/awsconfig"
 )
@@ -51,3 +64,224 @@ func TestHttp(t *testing.T) {
 		t.Errorf("secret error: expected=%s got=%s", expected, value)
 	}
 }
+
+type httpOptionsTestCase struct {
+	name          string
+	reference     string
+	expectedError bool
+	url           string
+	header        string
+	retries       int
+}
+
+var httpOptionsTestTable = []httpOptionsTestCase{
+	{"legacy", "#http::GET,https,host,443,/,text/plain,,Bearer secret:uri", expectOk, "https://host:443/", "", 2},
+	{"legacy options", "#http::GET,https,host,8443,/db,,,,header=X-Api-Key=k1,query=env=prod,query=a=b c,retries=0,status=200-204:uri", expectOk, "https://host:8443/db?a=b+c&env=prod", "k1", 0},
+	{"legacy token with comma", "#http::GET,https,host,443,/,,,a,b,timeout=5s", expectOk, "https://host:443/", "", 2},
+	{"legacy bad status", "#http::GET,https,host,443,/,,,,status=600", expectError, "", "", 0},
+	{"legacy bad timeout", "#http::GET,https,host,443,/,,,,timeout=5", expectError, "", "", 0},
+	{"legacy bad header", "#http::GET,https,host,443,/,,,,header=X-Api-Key", expectError, "", "", 0},
+	{"legacy cert without key", "#http::GET,https,host,443,/,,,,client-cert=/tmp/cert.pem", expectError, "", "", 0},
+	{"uri", "secret+http://host:8443/db?header=X-Api-Key%3Dk1&query=env%3Dprod&retries=1", expectOk, "https://host:8443/db?env=prod", "k1", 1},
+	{"uri unknown option", "secret+http://host:8443/db?headers=X-Api-Key%3Dk1", expectError, "", "", 0},
+}
+
+func TestParseHTTPOptions(t *testing.T) {
+	for _, data := range httpOptionsTestTable {
+		t.Run(data.name, func(t *testing.T) {
+			ref, errRef := ParseReference(data.reference)
+			if errRef != nil {
+				t.Fatalf("parse reference: %v", errRef)
+			}
+			opt, err := parseHTTPOptions(Query{Region: ref.Region, Name: ref.Location, Reference: ref})
+			if data.expectedError {
+				if err == nil {
+					t.Errorf("expected error, got: %+v", opt)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if opt.url != data.url {
+				t.Errorf("url: expected=%s got=%s", data.url, opt.url)
+			}
+			if h := opt.header.Get("X-Api-Key"); h != data.header {
+				t.Errorf("header: expected=%s got=%s", data.header, h)
+			}
+			if opt.retries != data.retries {
+				t.Errorf("retries: expected=%d got=%d", data.retries, opt.retries)
+			}
+		})
+	}
+}
+
+func TestHTTPHeadersAndRetry(t *testing.T) {
+
+	var calls atomic.Int32
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get("X-Api-Key") != "k1" || r.URL.Query().Get("env") != "prod" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"uri":"mongodb://db"}`)
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+
+	secret := New(Options{AwsConfigSource: &AwsConfigSource{}, CacheTTLSeconds: -1})
+
+	name := fmt.Sprintf("#http::GET,http,%s,%s,/,,,,header=X-Api-Key=k1,query=env=prod,status=200-201,retry-delay=1ms:uri",
+		u.Hostname(), u.Port())
+
+	value, err := secret.RetrieveWithError(name)
+	if err != nil {
+		t.Fatalf("retrieve: %v", err)
+	}
+	if value != "mongodb://db" {
+		t.Errorf("expected=mongodb://db got=%s", value)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("expected 3 calls, got %d", n)
+	}
+
+	// retries exhausted
+	calls.Store(0)
+	_, err = secret.RetrieveWithError(strings.Replace(name, "retry-delay=1ms", "retries=1", 1))
+	if !errors.Is(err, ErrBackendUnavailable) {
+		t.Errorf("expected ErrBackendUnavailable, got: %v", err)
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("expected 2 calls, got %d", n)
+	}
+
+	// status not accepted
+	_, err = secret.RetrieveWithError(strings.Replace(name, "status=200-201", "status=200", 1))
+	if err == nil {
+		t.Errorf("expected error for status 201")
+	}
+
+	// POST is not retried by default
+	calls.Store(0)
+	_, err = secret.RetrieveWithError(strings.Replace(name, "#http::GET,", "#http::POST,", 1))
+	if !errors.Is(err, ErrBackendUnavailable) {
+		t.Errorf("POST: expected ErrBackendUnavailable, got: %v", err)
+	}
+	if n := calls.Load(); n != 1 {
+		t.Errorf("POST: expected 1 call, got %d", n)
+	}
+
+	// POST with explicit retries
+	calls.Store(0)
+	post := strings.NewReplacer("#http::GET,", "#http::POST,", "retry-delay=1ms", "retry-delay=1ms,retries=2").Replace(name)
+	if value, err := secret.RetrieveWithError(post); err != nil || value != "mongodb://db" {
+		t.Errorf("POST with retries: value=%s err=%v", value, err)
+	}
+	if n := calls.Load(); n != 3 {
+		t.Errorf("POST with retries: expected 3 calls, got %d", n)
+	}
+}
+
+func TestHTTPTLS(t *testing.T) {
+
+	dir := t.TempDir()
+
+	clientCert, clientKey, clientPool := writeTestCert(t, dir, "client")
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"uri":"mongodb://tls"}`)
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientPool}
+	ts.StartTLS()
+	defer ts.Close()
+
+	ca := filepath.Join(dir, "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	if err := os.WriteFile(ca, caPEM, 0o600); err != nil {
+		t.Fatalf("write ca: %v", err)
+	}
+
+	u, _ := url.Parse(ts.URL)
+
+	secret := New(Options{AwsConfigSource: &AwsConfigSource{}, CacheTTLSeconds: -1})
+
+	name := fmt.Sprintf("secret+http://%s/?ca=%s&client-cert=%s&client-key=%s&retries=0&field=uri",
+		u.Host, url.QueryEscape(ca), url.QueryEscape(clientCert), url.QueryEscape(clientKey))
+
+	value, err := secret.RetrieveWithError(name)
+	if err != nil {
+		t.Fatalf("retrieve: %v", err)
+	}
+	if value != "mongodb://tls" {
+		t.Errorf("expected=mongodb://tls got=%s", value)
+	}
+
+	// without client certificate
+	if _, err := secret.RetrieveWithError(fmt.Sprintf("secret+http://%s/?ca=%s&retries=0", u.Host, url.QueryEscape(ca))); err == nil {
+		t.Errorf("expected error without client certificate")
+	}
+
+	// without ca
+	if _, err := secret.RetrieveWithError(fmt.Sprintf("secret+http://%s/?retries=0", u.Host)); err == nil {
+		t.Errorf("expected error for unknown authority")
+	}
+}
+
+// writeTestCert writes a self-signed certificate and key into dir.
+func writeTestCert(t *testing.T, dir, name string) (string, string, *x509.CertPool) {
+	t.Helper()
+
+	key, errKey := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if errKey != nil {
+		t.Fatalf("generate key: %v", errKey)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+
+	der, errCert := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if errCert != nil {
+		t.Fatalf("create certificate: %v", errCert)
+	}
+
+	keyDER, errMarshal := x509.MarshalECPrivateKey(key)
+	if errMarshal != nil {
+		t.Fatalf("marshal key: %v", errMarshal)
+	}
+
+	certFile := filepath.Join(dir, name+".pem")
+	keyFile := filepath.Join(dir, name+"-key.pem")
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf("write cert: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("write key: %v", err)
+	}
+
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return certFile, keyFile, pool
+}
@@ -2,13 +2,14 @@ package secret
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"net/http"
-	"strings"
+	"strconv"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/lambda"
-	"github.com/udhos/boilerplate/boilerplate"
 	"gopkg.in/yaml.v3"
 )
 
@@ -18,46 +19,62 @@ export DB_URI=aws-lambda:us-east-1:parameters,parameter,mongodb,body:uri
 #        Request: {"parameter":"mongodb"}
 # Response field: body
 #       Response: {"statusCode": 200,"body": "{\"uri\": \"mongodb://localhost:27017/?retryWrites=false\"}"}
-*/
-func queryLambda(_ /*debug*/ bool, _ /*printf*/ boilerplate.FuncPrintf, getAwsConfig AwsConfigSolver, lambdaOptions string) (string, error) {
-	const me = "queryLambda"
 
-	options := strings.SplitN(lambdaOptions, ",", 4)
-	if len(options) < 4 {
-		return "", fmt.Errorf("%s: bad lambda options, expecting 4 fields - got: '%s'",
-			me, lambdaOptions)
-	}
+export DB_URI=secret+aws-lambda://us-east-1/parameters?key=parameter&value=mongodb&response=body&field=uri
+
+Options:
 
-	functionName := options[0]
-	keyName := options[1]
-	keyValue := options[2]
-	responseField := options[3]
+	qualifier=alias              function version or alias
+	payload-base64=eyJhIjoxfQ==  request payload, base64-encoded JSON
+	payload={"a":1}              request payload, JSON (percent-escaped, URI syntax only)
 
-	request := fmt.Sprintf(`{"%s":"%s"}`, keyName, keyValue)
+With a payload option, key and value are left empty:
+
+export DB_URI=aws-lambda:us-east-1:parameters,,,body,qualifier=prod,payload-base64=eyJwYXJhbWV0ZXIiOiJtb25nb2RiIn0=:uri
+export DB_URI='secret+aws-lambda://us-east-1/parameters?qualifier=prod&payload=%7B%22parameter%22%3A%22mongodb%22%7D&field=uri'
+
+API Gateway style responses {"statusCode":200,"body":"..."} are unwrapped:
+statusCode other than 2xx is an error, and body is base64-decoded if
+isBase64Encoded is true. Response field "body", or no response field,
+returns the decoded body. Other response fields are extracted from
+the response payload, and can be nested paths.
+*/
+func queryLambda(ctx context.Context, q Query) (string, error) {
+	const me = "queryLambda"
 
-	requestBytes := []byte(request)
+	opt, errOptions := parseLambdaOptions(q)
+	if errOptions != nil {
+		return "", fmt.Errorf("%w: %s: bad lambda options: %w",
+			ErrMalformedReference, me, errOptions)
+	}
 
-	awsConfig, errAwsConfig := getAwsConfig.get()
+	awsConfig, errAwsConfig := q.AwsConfig(ctx)
 	if errAwsConfig != nil {
 		return "", errAwsConfig
 	}
 
 	clientLambda := lambda.NewFromConfig(awsConfig, func(o *lambda.Options) {
-		if endpoint := getAwsConfig.endpointURL(); endpoint != "" {
+		if endpoint := q.EndpointURL(); endpoint != "" {
 			o.BaseEndpoint = aws.String(endpoint)
 		}
 	})
 
 	input := &lambda.InvokeInput{
-		FunctionName: &functionName,
-		Payload:      requestBytes,
+		FunctionName: aws.String(opt.function),
+		Payload:      opt.payload,
+	}
+
+	if opt.qualifier != "" {
+		input.Qualifier = aws.String(opt.qualifier)
 	}
 
-	resp, errInvoke := clientLambda.Invoke(context.TODO(), input)
+	resp, errInvoke := clientLambda.Invoke(ctx, input)
 	if errInvoke != nil {
 		return "", errInvoke
 	}
 
+	functionName := opt.function
+
 	if resp.StatusCode != http.StatusOK {
 		return "", fmt.Errorf("%s: Invoke lambda function=%s bad status=%d payload: %s",
 			me, functionName, resp.StatusCode, resp.Payload)
@@ -72,18 +89,160 @@ func queryLambda(_ /*debug*/ bool, _ /*printf*/ boilerplate.FuncPrintf, getAwsCo
 			me, functionName, funcError, resp.Payload)
 	}
 
-	payload := map[string]string{}
+	return lambdaResponse(me, functionName, resp.Payload, opt.response)
+}
+
+type lambdaOptions struct {
+	function  string
+	qualifier string
+	payload   []byte
+	response  string
+}
+
+// lambdaOptionNames lists named options for legacy syntax,
+// where payload JSON would clash with the comma separator.
+var lambdaOptionNames = []string{"qualifier", "payload-base64"}
 
-	errUnmarshal := yaml.Unmarshal(resp.Payload, &payload)
-	if errUnmarshal != nil {
+func parseLambdaOptions(q Query) (lambdaOptions, error) {
+
+	options := q.Reference.Options
+
+	var positional []string
+
+	if q.Reference.URI {
+		for name := range options {
+			switch name {
+			case "qualifier", "payload-base64", "payload", "key", "value", "response":
+			default:
+				return lambdaOptions{}, fmt.Errorf("unknown option '%s'", name)
+			}
+		}
+		positional = []string{q.Name, options.Get("key"), options.Get("value"), options.Get("response")}
+	} else {
+		q.Name, options = trailingOptions(q.Name, lambdaOptionNames)
+		var err error
+		positional, err = referenceOptions(q, ",", "key", "value", "response")
+		if err != nil {
+			return lambdaOptions{}, err
+		}
+	}
+
+	opt := lambdaOptions{
+		function:  positional[0],
+		qualifier: options.Get("qualifier"),
+		response:  positional[3],
+	}
+
+	if opt.function == "" {
+		return opt, fmt.Errorf("missing function name")
+	}
+
+	keyName, keyValue := positional[1], positional[2]
+
+	sources := 0
+	for _, given := range []bool{keyName != "", options.Has("payload"), options.Has("payload-base64")} {
+		if given {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return opt, fmt.Errorf("key/value, payload and payload-base64 are mutually exclusive")
+	}
+
+	switch {
+	case keyName != "":
+		payload, err := json.Marshal(map[string]string{keyName: keyValue})
+		if err != nil {
+			return opt, err
+		}
+		opt.payload = payload
+	case options.Has("payload"):
+		opt.payload = []byte(options.Get("payload"))
+	case options.Has("payload-base64"):
+		payload, err := base64.StdEncoding.DecodeString(options.Get("payload-base64"))
+		if err != nil {
+			return opt, fmt.Errorf("payload-base64: %w", err)
+		}
+		opt.payload = payload
+	}
+
+	if opt.payload != nil && !json.Valid(opt.payload) {
+		return opt, fmt.Errorf("payload is not valid JSON: %s", opt.payload)
+	}
+
+	return opt, nil
+}
+
+// lambdaResponse extracts the response from the lambda payload,
+// unwrapping API Gateway style responses.
+func lambdaResponse(me, functionName string, payload []byte, responseField string) (string, error) {
+
+	var doc any
+
+	if errUnmarshal := yaml.Unmarshal(payload, &doc); errUnmarshal != nil {
 		return "", errUnmarshal
 	}
 
-	response, found := payload[responseField]
+	doc = normalize(doc)
+
+	obj, _ := doc.(map[string]any)
+
+	body, isGateway := apiGatewayBody(obj)
+	if isGateway {
+		status, errStatus := strconv.Atoi(fmt.Sprint(obj["statusCode"]))
+		if errStatus != nil {
+			return "", fmt.Errorf("%s: Invoke lambda function=%s: bad statusCode: %s",
+				me, functionName, payload)
+		}
+		if status < 200 || status > 299 {
+			return "", statusError(me, "lambda:"+functionName, status, body)
+		}
+
+		if b64, _ := obj["isBase64Encoded"].(bool); b64 {
+			decoded, errDecode := base64.StdEncoding.DecodeString(body)
+			if errDecode != nil {
+				return "", fmt.Errorf("%s: Invoke lambda function=%s: decode base64 body: %w",
+					me, functionName, errDecode)
+			}
+			body = string(decoded)
+		}
+
+		if responseField == "" || responseField == "body" {
+			return body, nil
+		}
+	}
+
+	if responseField == "" {
+		return string(payload), nil
+	}
+
+	response, found, errField := extractField(string(payload), responseField)
+	if errField != nil {
+		return "", errField
+	}
 	if !found {
-		return "", fmt.Errorf("%s: Invoke lambda function=%s: missing response field: '%s': %s",
-			me, functionName, responseField, resp.Payload)
+		return "", fmt.Errorf("%w: %s: Invoke lambda function=%s: missing response field: '%s': %s",
+			ErrFieldMissing, me, functionName, responseField, payload)
 	}
 
 	return response, nil
 }
+
+// apiGatewayBody recognizes {"statusCode":200,"body":"..."} responses.
+func apiGatewayBody(obj map[string]any) (string, bool) {
+	if obj == nil {
+		return "", false
+	}
+	if _, hasStatus := obj["statusCode"]; !hasStatus {
+		return "", false
+	}
+	body, hasBody := obj["body"]
+	if !hasBody {
+		return "", false
+	}
+	if str, isStr := body.(string); isStr {
+		return str, true
+	}
+	str, err := fieldString(body)
+	return str, err == nil
+}
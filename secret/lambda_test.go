@@ -0,0 +1,107 @@
+package secret
+
+import (
+	"errors"
+	"testing"
+)
+
+type lambdaOptionsTestCase struct {
+	name          string
+	reference     string
+	expectedError bool
+	function      string
+	qualifier     string
+	payload       string
+	response      string
+}
+
+var lambdaOptionsTestTable = []lambdaOptionsTestCase{
+	{"legacy", "aws-lambda:us-east-1:parameters,parameter,mongodb,body:uri", expectOk, "parameters", "", `{"parameter":"mongodb"}`, "body"},
+	{"legacy quotes", `aws-lambda:us-east-1:parameters,parameter,say "hi",body`, expectOk, "parameters", "", `{"parameter":"say \"hi\""}`, "body"},
+	{"legacy qualifier", "aws-lambda:us-east-1:parameters,parameter,mongodb,body,qualifier=prod", expectOk, "parameters", "prod", `{"parameter":"mongodb"}`, "body"},
+	{"legacy payload", "aws-lambda:us-east-1:parameters,,,body,payload-base64=eyJwYXJhbWV0ZXIiOiJtb25nb2RiIn0=", expectOk, "parameters", "", `{"parameter":"mongodb"}`, "body"},
+	{"legacy payload and key", "aws-lambda:us-east-1:parameters,parameter,mongodb,body,payload-base64=e30=", expectError, "", "", "", ""},
+	{"legacy bad base64", "aws-lambda:us-east-1:parameters,,,body,payload-base64=%%%", expectError, "", "", "", ""},
+	{"legacy bad json", "aws-lambda:us-east-1:parameters,,,body,payload-base64=e30", expectError, "", "", "", ""},
+	{"legacy missing response", "aws-lambda:us-east-1:parameters,parameter,mongodb", expectError, "", "", "", ""},
+	{"uri", "secret+aws-lambda://us-east-1/parameters?key=parameter&value=mongodb&response=body&field=uri", expectOk, "parameters", "", `{"parameter":"mongodb"}`, "body"},
+	{"uri payload", "secret+aws-lambda://us-east-1/parameters?qualifier=3&payload=%7B%22a%22%3A%5B1%2C2%5D%7D", expectOk, "parameters", "3", `{"a":[1,2]}`, ""},
+	{"uri no payload", "secret+aws-lambda://us-east-1/parameters", expectOk, "parameters", "", "", ""},
+	{"uri bad payload", "secret+aws-lambda://us-east-1/parameters?payload=%7B", expectError, "", "", "", ""},
+	{"uri unknown option", "secret+aws-lambda://us-east-1/parameters?version=3", expectError, "", "", "", ""},
+}
+
+func TestParseLambdaOptions(t *testing.T) {
+	for _, data := range lambdaOptionsTestTable {
+		t.Run(data.name, func(t *testing.T) {
+			ref, errRef := ParseReference(data.reference)
+			if errRef != nil {
+				t.Fatalf("parse reference: %v", errRef)
+			}
+			opt, err := parseLambdaOptions(Query{Region: ref.Region, Name: ref.Location, Reference: ref})
+			if data.expectedError {
+				if err == nil {
+					t.Errorf("expected error, got: %+v", opt)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if opt.function != data.function {
+				t.Errorf("function: expected=%s got=%s", data.function, opt.function)
+			}
+			if opt.qualifier != data.qualifier {
+				t.Errorf("qualifier: expected=%s got=%s", data.qualifier, opt.qualifier)
+			}
+			if string(opt.payload) != data.payload {
+				t.Errorf("payload: expected=%s got=%s", data.payload, opt.payload)
+			}
+			if opt.response != data.response {
+				t.Errorf("response: expected=%s got=%s", data.response, opt.response)
+			}
+		})
+	}
+}
+
+type lambdaResponseTestCase struct {
+	name          string
+	payload       string
+	response      string
+	expectedError error
+	expected      string
+}
+
+var lambdaResponseTestTable = []lambdaResponseTestCase{
+	{"gateway body", `{"statusCode":200,"body":"{\"uri\": \"mongodb://db\"}"}`, "body", nil, `{"uri": "mongodb://db"}`},
+	{"gateway no response field", `{"statusCode":200,"body":"plain"}`, "", nil, "plain"},
+	{"gateway base64", `{"statusCode":200,"isBase64Encoded":true,"body":"eyJ1cmkiOiJkYiJ9"}`, "body", nil, `{"uri":"db"}`},
+	{"gateway object body", `{"statusCode":200,"body":{"uri":"db"}}`, "body", nil, `{"uri":"db"}`},
+	{"gateway other field", `{"statusCode":200,"body":"x","headers":{"etag":"v1"}}`, "headers.etag", nil, "v1"},
+	{"gateway forbidden", `{"statusCode":403,"body":"forbidden"}`, "body", ErrAccessDenied, ""},
+	{"gateway not found", `{"statusCode":404,"body":"missing"}`, "body", ErrNotFound, ""},
+	{"flat", `{"uri":"mongodb://db"}`, "uri", nil, "mongodb://db"},
+	{"nested", `{"db":{"port":27017}}`, "db.port", nil, "27017"},
+	{"whole payload", `{"uri":"mongodb://db"}`, "", nil, `{"uri":"mongodb://db"}`},
+	{"missing field", `{"uri":"mongodb://db"}`, "body", ErrFieldMissing, ""},
+}
+
+func TestLambdaResponse(t *testing.T) {
+	for _, data := range lambdaResponseTestTable {
+		t.Run(data.name, func(t *testing.T) {
+			value, err := lambdaResponse("test", "parameters", []byte(data.payload), data.response)
+			if data.expectedError != nil {
+				if !errors.Is(err, data.expectedError) {
+					t.Errorf("expected error %v, got: value=%s err=%v", data.expectedError, value, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if value != data.expected {
+				t.Errorf("expected=%s got=%s", data.expected, value)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package secret
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+OAuth2 client credentials authentication for the #http backend:
+
+	auth=oauth2                    obtain access token from token endpoint
+	token-url=https://auth/token   token endpoint
+	client-id=id                   client id, literal or secret reference
+	client-secret=secret           client secret, literal or secret reference
+	scope=read:secrets             optional scope, space separated
+	client-auth=basic|post         send client credentials as basic auth (default) or in form body
+
+Since token-url holds ':', legacy syntax requires another separator, like '|':
+
+export DB_URI='#http||GET,https,secrets.internal,443,/db,,,,auth=oauth2,token-url=https://auth.internal/token,client-id=app,client-secret=aws-secretsmanager:us-east-1:oauth:secret|uri'
+
+export DB_URI='secret+http://secrets.internal/db?auth=oauth2&token-url=https%3A%2F%2Fauth.internal%2Ftoken&client-id=app&client-secret=aws-secretsmanager%3Aus-east-1%3Aoauth%3Asecret&field=uri'
+
+Tokens are cached by the Secret until expires_in, and refreshed when the
+server responds with 401. Secret.Close discards cached tokens.
+*/
+
+type oauth2Options struct {
+	tokenURL     string
+	clientID     string
+	clientSecret string
+	scope        string
+	clientAuth   string // basic|post
+}
+
+// oauth2OptionNames lists options for OAuth2 authentication.
+var oauth2OptionNames = []string{"auth", "token-url", "client-id", "client-secret", "scope", "client-auth"}
+
+func parseOAuth2Options(options url.Values) (*oauth2Options, error) {
+	if options.Get("auth") != "oauth2" {
+		for _, name := range oauth2OptionNames[1:] {
+			if options.Has(name) {
+				return nil, fmt.Errorf("option '%s' requires auth=oauth2", name)
+			}
+		}
+		return nil, nil
+	}
+
+	opt := &oauth2Options{
+		tokenURL:     options.Get("token-url"),
+		clientID:     options.Get("client-id"),
+		clientSecret: options.Get("client-secret"),
+		scope:        options.Get("scope"),
+		clientAuth:   options.Get("client-auth"),
+	}
+
+	if opt.tokenURL == "" || opt.clientID == "" {
+		return nil, fmt.Errorf("auth=oauth2 requires token-url and client-id")
+	}
+
+	switch opt.clientAuth {
+	case "":
+		opt.clientAuth = "basic"
+	case "basic", "post":
+	default:
+		return nil, fmt.Errorf("bad client-auth '%s', expecting basic|post", opt.clientAuth)
+	}
+
+	return opt, nil
+}
+
+type oauth2Token struct {
+	mutex         sync.Mutex
+	authorization string
+	expire        time.Time // zero for tokens without expiration
+}
+
+// oauth2Expiry is subtracted from token expiration, so that
+// tokens are refreshed before the server rejects them.
+const oauth2Expiry = 30 * time.Second
+
+// authorization returns the Authorization header with a cached or new token.
+// invalid is the header rejected by the server, to be replaced with a new token.
+func (o *oauth2Options) authorization(ctx context.Context, q Query, client *http.Client, invalid string) (string, error) {
+	const me = "oauth2Options.authorization"
+
+	clientID, errID := q.Retrieve(ctx, o.clientID)
+	if errID != nil {
+		return "", fmt.Errorf("%s: client-id: %w", me, errID)
+	}
+
+	clientSecret, errSecret := q.Retrieve(ctx, o.clientSecret)
+	if errSecret != nil {
+		return "", fmt.Errorf("%s: client-secret: %w", me, errSecret)
+	}
+
+	// tokens are cached by the Secret, keyed by endpoint and resolved credentials
+	token := &oauth2Token{}
+	if q.secret != nil {
+		sum := sha256.Sum256([]byte(strings.Join([]string{o.tokenURL, clientID, clientSecret, o.scope, o.clientAuth}, "\x00")))
+		t, _ := q.secret.oauth2Tokens.LoadOrStore(hex.EncodeToString(sum[:]), token)
+		token = t.(*oauth2Token)
+	}
+
+	token.mutex.Lock()
+	defer token.mutex.Unlock()
+
+	valid := token.authorization != "" && token.authorization != invalid &&
+		(token.expire.IsZero() || time.Now().Before(token.expire))
+	if valid {
+		return token.authorization, nil
+	}
+
+	authorization, expire, errFetch := o.fetch(ctx, client, clientID, clientSecret)
+	if errFetch != nil {
+		return "", fmt.Errorf("%s: %w", me, errFetch)
+	}
+
+	if q.Debug {
+		q.Printf("DEBUG %s: token-url=%s client-id=%s: new token expire=%v",
+			me, o.tokenURL, clientID, expire)
+	}
+
+	token.authorization = authorization
+	token.expire = expire
+
+	return authorization, nil
+}
+
+// fetch requests a new token from the token endpoint.
+func (o *oauth2Options) fetch(ctx context.Context, client *http.Client,
+	clientID, clientSecret string) (string, time.Time, error) {
+	const me = "oauth2Options.fetch"
+
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if o.scope != "" {
+		form.Set("scope", o.scope)
+	}
+	if o.clientAuth == "post" {
+		form.Set("client_id", clientID)
+		form.Set("client_secret", clientSecret)
+	}
+
+	req, errReq := http.NewRequestWithContext(ctx, http.MethodPost, o.tokenURL, strings.NewReader(form.Encode()))
+	if errReq != nil {
+		return "", time.Time{}, errReq
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	if o.clientAuth == "basic" {
+		req.SetBasicAuth(url.QueryEscape(clientID), url.QueryEscape(clientSecret))
+	}
+
+	resp, errDo := client.Do(req)
+	if errDo != nil {
+		return "", time.Time{}, errDo
+	}
+
+	defer resp.Body.Close()
+
+	body, errRead := io.ReadAll(resp.Body)
+	if errRead != nil {
+		return "", time.Time{}, errRead
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", time.Time{}, statusError(me, o.tokenURL, resp.StatusCode, string(body))
+	}
+
+	var response struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+
+	if errJSON := json.Unmarshal(body, &response); errJSON != nil {
+		return "", time.Time{}, fmt.Errorf("%s: token-url=%s: bad response: %w", me, o.tokenURL, errJSON)
+	}
+
+	if response.AccessToken == "" {
+		return "", time.Time{}, fmt.Errorf("%s: token-url=%s: missing access_token", me, o.tokenURL)
+	}
+
+	tokenType := response.TokenType
+	if tokenType == "" || strings.EqualFold(tokenType, "bearer") {
+		tokenType = "Bearer"
+	}
+
+	var expire time.Time
+	if response.ExpiresIn > 0 {
+		expire = time.Now().Add(time.Duration(response.ExpiresIn)*time.Second - oauth2Expiry)
+	}
+
+	return tokenType + " " + response.AccessToken, expire, nil
+}
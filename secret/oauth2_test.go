@@ -0,0 +1,149 @@
+package secret
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+type oauth2OptionsTestCase struct {
+	name          string
+	options       string
+	expectedError bool
+	enabled       bool
+}
+
+var oauth2OptionsTestTable = []oauth2OptionsTestCase{
+	{"disabled", "", expectOk, false},
+	{"enabled", "auth=oauth2&token-url=https://auth/token&client-id=app&client-secret=s", expectOk, true},
+	{"post", "auth=oauth2&token-url=https://auth/token&client-id=app&client-auth=post", expectOk, true},
+	{"bad client-auth", "auth=oauth2&token-url=https://auth/token&client-id=app&client-auth=jwt", expectError, false},
+	{"missing token-url", "auth=oauth2&client-id=app", expectError, false},
+	{"other auth", "auth=sigv4", expectOk, false},
+	{"without auth", "token-url=https://auth/token", expectError, false},
+}
+
+func TestParseOAuth2Options(t *testing.T) {
+	for _, data := range oauth2OptionsTestTable {
+		t.Run(data.name, func(t *testing.T) {
+			options, _ := url.ParseQuery(data.options)
+			opt, err := parseOAuth2Options(options)
+			if data.expectedError {
+				if err == nil {
+					t.Errorf("expected error, got: %+v", opt)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if (opt != nil) != data.enabled {
+				t.Errorf("enabled: expected=%t got=%+v", data.enabled, opt)
+			}
+		})
+	}
+}
+
+func TestHTTPOAuth2(t *testing.T) {
+
+	var tokens atomic.Int32
+	var current sync.Map // valid tokens
+
+	auth := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != "app" || secret != "s3cr3t" || r.FormValue("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		token := fmt.Sprintf("t%d", tokens.Add(1))
+		current.Store(token, true)
+		fmt.Fprintf(w, `{"access_token":"%s","token_type":"bearer","expires_in":3600}`, token)
+	}))
+	defer auth.Close()
+
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var token string
+		fmt.Sscanf(r.Header.Get("Authorization"), "Bearer %s", &token)
+		if _, valid := current.Load(token); !valid {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"uri":"mongodb://oauth2"}`)
+	}))
+	defer api.Close()
+
+	secret := New(Options{AwsConfigSource: &AwsConfigSource{}, CacheTTLSeconds: -1})
+
+	// client secret from another store
+	secret.RegisterBackend("my-store", BackendFunc(func(_ context.Context, q Query) (string, error) {
+		return `{"secret":"s3cr3t"}`, nil
+	}))
+
+	u, _ := url.Parse(api.URL)
+
+	name := fmt.Sprintf("secret+http://%s/?proto=http&auth=oauth2&token-url=%s&client-id=app&client-secret=%s&retries=0&field=uri",
+		u.Host, url.QueryEscape(auth.URL), url.QueryEscape("my-store:us-east-1:oauth:secret"))
+
+	for range 3 {
+		value, err := secret.RetrieveWithError(name)
+		if err != nil {
+			t.Fatalf("retrieve: %v", err)
+		}
+		if value != "mongodb://oauth2" {
+			t.Errorf("expected=mongodb://oauth2 got=%s", value)
+		}
+	}
+
+	if n := tokens.Load(); n != 1 {
+		t.Errorf("expected cached token, got %d tokens", n)
+	}
+
+	// revoke token: refreshed on 401
+	current.Delete("t1")
+
+	value, err := secret.RetrieveWithError(name)
+	if err != nil {
+		t.Fatalf("retrieve after revoke: %v", err)
+	}
+	if value != "mongodb://oauth2" {
+		t.Errorf("expected=mongodb://oauth2 got=%s", value)
+	}
+	if n := tokens.Load(); n != 2 {
+		t.Errorf("expected refreshed token, got %d tokens", n)
+	}
+
+	// legacy syntax with alternative separator
+	legacy := fmt.Sprintf("#http||GET,http,%s,%s,/,,,,auth=oauth2,token-url=%s,client-id=app,client-secret=my-store:us-east-1:oauth:secret|uri",
+		u.Hostname(), u.Port(), auth.URL)
+	if value, err := secret.RetrieveWithError(legacy); err != nil || value != "mongodb://oauth2" {
+		t.Errorf("legacy: value=%s err=%v", value, err)
+	}
+
+	// tokens are cached per Secret
+	other := New(Options{AwsConfigSource: &AwsConfigSource{}, CacheTTLSeconds: -1})
+	other.RegisterBackend("my-store", BackendFunc(func(_ context.Context, q Query) (string, error) {
+		return `{"secret":"s3cr3t"}`, nil
+	}))
+	if _, err := other.RetrieveWithError(name); err != nil {
+		t.Fatalf("other secret: %v", err)
+	}
+	if n := tokens.Load(); n != 3 {
+		t.Errorf("expected token for other secret, got %d tokens", n)
+	}
+
+	// close discards cached tokens
+	if err := secret.Close(); err != nil {
+		t.Errorf("close: %v", err)
+	}
+	if _, err := secret.RetrieveWithError(name); err != nil {
+		t.Fatalf("retrieve after close: %v", err)
+	}
+	if n := tokens.Load(); n != 4 {
+		t.Errorf("expected new token after close, got %d tokens", n)
+	}
+}
@@ -2,31 +2,61 @@ package secret
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ssm"
-	"github.com/udhos/boilerplate/boilerplate"
 )
 
-func queryParameter(_ /*debug*/ bool, _ /*printf*/ boilerplate.FuncPrintf, getAwsConfig AwsConfigSolver, parameterName string) (string, error) {
+/*
+aws-parameterstore:region:parameter_name[,version=N][,label=L][:field]
 
-	awsConfig, errAwsConfig := getAwsConfig.get()
+export DB_URI=aws-parameterstore:us-east-1:/myapp/mongodb,version=3:uri
+export DB_URI=aws-parameterstore:us-east-1:/myapp/mongodb,label=prod:uri
+export DB_URI='aws-parameterstore|us-east-1|/myapp/mongodb:prod|uri'  # native selector
+
+A name ending with '/' loads the whole hierarchy with GetParametersByPath,
+returned as JSON object keyed by parameter name relative to the path:
+
+export DB_URI=aws-parameterstore:us-east-1:/myapp/prod/:mongodb/uri
+export DB_URI=aws-parameterstore:us-east-1:/myapp/prod/,recursive=false
+
+Hierarchical parameter names keep their leading slash in URI syntax:
+
+	secret+aws-parameterstore://us-east-1//myapp/mongodb?field=uri
+*/
+func queryParameter(ctx context.Context, q Query) (string, error) {
+	const me = "queryParameter"
+
+	opt, errOptions := parseParameterOptions(q)
+	if errOptions != nil {
+		return "", fmt.Errorf("%w: %s: %w", ErrMalformedReference, me, errOptions)
+	}
+
+	awsConfig, errAwsConfig := q.AwsConfig(ctx)
 	if errAwsConfig != nil {
 		return "", errAwsConfig
 	}
 
 	sm := ssm.NewFromConfig(awsConfig, func(o *ssm.Options) {
-		if endpoint := getAwsConfig.endpointURL(); endpoint != "" {
+		if endpoint := q.EndpointURL(); endpoint != "" {
 			o.BaseEndpoint = aws.String(endpoint)
 		}
 	})
 
+	if opt.path {
+		return queryParameterPath(ctx, sm, opt)
+	}
+
 	input := &ssm.GetParameterInput{
-		Name:           aws.String(parameterName),
+		Name:           aws.String(opt.name),
 		WithDecryption: aws.Bool(true),
 	}
 
-	resp, errParameter := sm.GetParameter(context.TODO(), input)
+	resp, errParameter := sm.GetParameter(ctx, input)
 
 	if errParameter != nil {
 		return "", errParameter
@@ -34,3 +64,190 @@ func queryParameter(_ /*debug*/ bool, _ /*printf*/ boilerplate.FuncPrintf, getAw
 
 	return *resp.Parameter.Value, nil
 }
+
+// queryParameterPath loads a parameter hierarchy as JSON object.
+func queryParameterPath(ctx context.Context, sm *ssm.Client, opt parameterOptions) (string, error) {
+	const me = "queryParameterPath"
+
+	path := strings.TrimSuffix(opt.name, "/")
+	if path == "" {
+		path = "/"
+	}
+	prefix := strings.TrimSuffix(path, "/") + "/"
+
+	input := &ssm.GetParametersByPathInput{
+		Path:           aws.String(path),
+		Recursive:      aws.Bool(opt.recursive),
+		WithDecryption: aws.Bool(true),
+	}
+
+	params := map[string]string{}
+
+	paginator := ssm.NewGetParametersByPathPaginator(sm, input)
+	for paginator.HasMorePages() {
+		page, errPage := paginator.NextPage(ctx)
+		if errPage != nil {
+			return "", errPage
+		}
+		for _, p := range page.Parameters {
+			name := strings.TrimPrefix(aws.ToString(p.Name), prefix)
+			params[name] = aws.ToString(p.Value)
+		}
+	}
+
+	if len(params) == 0 {
+		return "", fmt.Errorf("%w: %s: no parameters under path: %s", ErrNotFound, me, path)
+	}
+
+	data, errJSON := json.Marshal(params)
+	return string(data), errJSON
+}
+
+type parameterOptions struct {
+	name      string // name with optional selector: name:3 or name:label
+	path      bool
+	recursive bool
+}
+
+func parseParameterOptions(q Query) (parameterOptions, error) {
+
+	name, options, errOptions := namedOptions(q)
+	if errOptions != nil {
+		return parameterOptions{}, errOptions
+	}
+
+	for opt := range options {
+		switch opt {
+		case "version", "label", "recursive":
+		default:
+			return parameterOptions{}, fmt.Errorf("unknown option '%s', expecting version|label|recursive: %s",
+				opt, q.Name)
+		}
+	}
+
+	opt := parameterOptions{
+		name:      name,
+		path:      strings.HasSuffix(name, "/"),
+		recursive: true,
+	}
+
+	if opt.name == "" {
+		return opt, fmt.Errorf("empty parameter name: %s", q.Name)
+	}
+
+	version := options.Get("version")
+	label := options.Get("label")
+
+	switch {
+	case version != "" && label != "":
+		return opt, fmt.Errorf("version and label are mutually exclusive: %s", q.Name)
+	case version != "":
+		if _, errVersion := strconv.ParseUint(version, 10, 64); errVersion != nil {
+			return opt, fmt.Errorf("bad version '%s': %w", version, errVersion)
+		}
+		opt.name += ":" + version
+	case label != "":
+		opt.name += ":" + label
+	}
+
+	if recursive := options.Get("recursive"); recursive != "" {
+		if !opt.path {
+			return opt, fmt.Errorf("recursive option requires path ending with '/': %s", q.Name)
+		}
+		r, errBool := strconv.ParseBool(recursive)
+		if errBool != nil {
+			return opt, fmt.Errorf("bad recursive option: %w", errBool)
+		}
+		opt.recursive = r
+	}
+
+	if opt.path && (version != "" || label != "") {
+		return opt, fmt.Errorf("path does not support version or label: %s", q.Name)
+	}
+
+	return opt, nil
+}
+
+// queryParameterBatch fetches parameters with GetParameters, up to 10 parameters per call.
+// Paths fall back to GetParametersByPath.
+func queryParameterBatch(ctx context.Context, queries []Query) []BatchResult {
+	const me = "queryParameterBatch"
+
+	results := make([]BatchResult, len(queries))
+
+	var batch []Query
+	var batchIndex []int
+
+	for i, q := range queries {
+		opt, errOptions := parseParameterOptions(q)
+		switch {
+		case errOptions != nil:
+			results[i].Err = fmt.Errorf("%w: %s: %w", ErrMalformedReference, me, errOptions)
+		case opt.path:
+			results[i].Value, results[i].Err = queryParameter(ctx, q)
+		default:
+			batch = append(batch, q)
+			batchIndex = append(batchIndex, i)
+		}
+	}
+
+	if len(batch) == 0 {
+		return results
+	}
+
+	awsConfig, errAwsConfig := batch[0].AwsConfig(ctx)
+
+	sm := ssm.NewFromConfig(awsConfig, func(o *ssm.Options) {
+		if endpoint := batch[0].EndpointURL(); endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	})
+
+	fetch := func(names []string) (map[string]string, error) {
+		if errAwsConfig != nil {
+			return nil, errAwsConfig
+		}
+
+		input := &ssm.GetParametersInput{
+			Names:          names,
+			WithDecryption: aws.Bool(true),
+		}
+
+		resp, errParameters := sm.GetParameters(ctx, input)
+		if errParameters != nil {
+			return nil, errParameters
+		}
+
+		// invalid parameters are missing from map, hence reported as not found
+
+		found := map[string]string{}
+		for _, p := range resp.Parameters {
+			value := aws.ToString(p.Value)
+			name := aws.ToString(p.Name)
+			arn := aws.ToString(p.ARN)
+			if p.Selector != nil {
+				// version or label: must not replace the plain name
+				found[name+*p.Selector] = value
+				found[arn+*p.Selector] = value
+				continue
+			}
+			found[name] = value
+			found[arn] = value
+		}
+
+		return found, nil
+	}
+
+	keyOf := func(q Query) (string, error) {
+		opt, errOptions := parseParameterOptions(q)
+		return opt.name, errOptions
+	}
+
+	valueOf := func(_ Query, value string) (string, error) { return value, nil }
+
+	for i, r := range batchByKey(batch, 10, keyOf, fetch, valueOf) {
+		results[batchIndex[i]] = r
+	}
+
+	return results
+}
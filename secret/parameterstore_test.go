@@ -0,0 +1,143 @@
+package secret
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+type parameterOptionsTestCase struct {
+	name          string
+	reference     string
+	expectedError bool
+	parameter     string
+	path          bool
+	recursive     bool
+}
+
+var parameterOptionsTestTable = []parameterOptionsTestCase{
+	{"name", "aws-parameterstore:us-east-1:/app/db:uri", expectOk, "/app/db", false, true},
+	{"version", "aws-parameterstore:us-east-1:/app/db,version=3:uri", expectOk, "/app/db:3", false, true},
+	{"bad version", "aws-parameterstore:us-east-1:/app/db,version=x", expectError, "", false, false},
+	{"label", "aws-parameterstore:us-east-1:/app/db,label=prod", expectOk, "/app/db:prod", false, true},
+	{"version and label", "aws-parameterstore:us-east-1:/app/db,version=3,label=prod", expectError, "", false, false},
+	{"native selector", "aws-parameterstore|us-east-1|/app/db:prod|uri", expectOk, "/app/db:prod", false, true},
+	{"path", "aws-parameterstore:us-east-1:/app/prod/:db/uri", expectOk, "/app/prod/", true, true},
+	{"path not recursive", "aws-parameterstore:us-east-1:/app/prod/,recursive=false", expectOk, "/app/prod/", true, false},
+	{"path with version", "aws-parameterstore:us-east-1:/app/prod/,version=3", expectError, "", false, false},
+	{"recursive without path", "aws-parameterstore:us-east-1:/app/db,recursive=true", expectError, "", false, false},
+	{"unknown option", "aws-parameterstore:us-east-1:/app/db,stage=x", expectError, "", false, false},
+	{"uri", "secret+aws-parameterstore://us-east-1//app/db?label=prod&field=uri", expectOk, "/app/db:prod", false, true},
+	{"uri path", "secret+aws-parameterstore://us-east-1//app/prod/?recursive=false", expectOk, "/app/prod/", true, false},
+}
+
+func TestParseParameterOptions(t *testing.T) {
+	for _, data := range parameterOptionsTestTable {
+		t.Run(data.name, func(t *testing.T) {
+			ref, errRef := ParseReference(data.reference)
+			if errRef != nil {
+				t.Fatalf("parse reference: %v", errRef)
+			}
+			opt, err := parseParameterOptions(Query{Region: ref.Region, Name: ref.Location, Reference: ref})
+			if data.expectedError {
+				if err == nil {
+					t.Errorf("expected error, got: %+v", opt)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			expected := parameterOptions{name: data.parameter, path: data.path, recursive: data.recursive}
+			if opt != expected {
+				t.Errorf("expected=%+v got=%+v", expected, opt)
+			}
+		})
+	}
+}
+
+func TestRetrieveMap(t *testing.T) {
+
+	secret := New(Options{AwsConfigSource: &AwsConfigSource{}})
+
+	secret.RegisterBackend("map-store", BackendFunc(func(_ context.Context, q Query) (string, error) {
+		if q.Name == "scalar" {
+			return "text", nil
+		}
+		return `{"db/uri":"mongodb://db","port":27017,"hosts":["a","b"]}`, nil
+	}))
+
+	m, err := secret.RetrieveMap(context.Background(), "map-store::params")
+	if err != nil {
+		t.Fatalf("retrieve map: %v", err)
+	}
+
+	expected := map[string]string{"db/uri": "mongodb://db", "port": "27017", "hosts": `["a","b"]`}
+	if len(m) != len(expected) {
+		t.Errorf("expected=%v got=%v", expected, m)
+	}
+	for k, v := range expected {
+		if m[k] != v {
+			t.Errorf("key=%s expected=%s got=%s", k, v, m[k])
+		}
+	}
+
+	if _, err := secret.RetrieveMap(context.Background(), "map-store::scalar"); err == nil {
+		t.Errorf("expected error for scalar secret")
+	}
+}
+
+func TestParameterBatchSelector(t *testing.T) {
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input struct{ Names []string }
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &input); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// selector results last, so they would overwrite the plain name
+		var params []map[string]string
+		for _, name := range input.Names {
+			if name == "/app/db" {
+				params = append(params, map[string]string{"Name": "/app/db", "Value": "latest",
+					"ARN": "arn:aws:ssm:us-east-1:123456789012:parameter/app/db"})
+			}
+		}
+		for _, name := range input.Names {
+			if name == "/app/db:1" {
+				params = append(params, map[string]string{"Name": "/app/db", "Value": "version-1", "Selector": ":1",
+					"ARN": "arn:aws:ssm:us-east-1:123456789012:parameter/app/db"})
+			}
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		json.NewEncoder(w).Encode(map[string]any{"Parameters": params, "InvalidParameters": []string{}})
+	}))
+	defer ts.Close()
+
+	creds := aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "SECRET"}
+
+	secret := New(Options{AwsConfigSource: &staticAwsConfig{credentials: creds, endpoint: ts.URL}})
+
+	const (
+		plain     = "aws-parameterstore:us-east-1:/app/db"
+		versioned = "aws-parameterstore:us-east-1:/app/db,version=1"
+	)
+
+	values, err := secret.RetrieveMany(context.TODO(), []string{plain, versioned})
+	if err != nil {
+		t.Fatalf("retrieve many: %v", err)
+	}
+
+	if values[plain] != "latest" {
+		t.Errorf("plain name: expected=latest got=%s", values[plain])
+	}
+	if values[versioned] != "version-1" {
+		t.Errorf("versioned name: expected=version-1 got=%s", values[versioned])
+	}
+}
@@ -2,50 +2,37 @@ package secret
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"strings"
-
-	"github.com/udhos/boilerplate/boilerplate"
 )
 
 /*
 proxy||proto,host,port,secret_name[|field_name]
 
 export DB_URI=proxy||http,localhost,8080,vault::token,dev-only-token,http,localhost,8200,secret/myapp1/mongodb:uri
-*/
-func queryProxy(debug bool, printf boilerplate.FuncPrintf,
-	_ /*unused*/ AwsConfigSolver, proxyOptions string) (string, error) {
-	const me = "queryProxy"
 
-	const fields = 4
-
-	options := strings.SplitN(proxyOptions, ",", fields)
-	if len(options) < fields {
-		return "", fmt.Errorf("%s: bad proxy options, expecting %d fields - got: '%s'",
-			me, fields, proxyOptions)
-	}
+secret+proxy://host:port/secret_name[?proto=https]
 
-	// remove spaces
-	for i, s := range options {
-		options[i] = strings.TrimSpace(s)
-	}
+Reserved characters '?', '#' and '%' in the inner secret_name are percent-escaped.
 
-	proto := options[0]
-	host := options[1]
-	port := options[2]
-	secretName := options[3]
+export DB_URI=secret+proxy://localhost:8080/vault::token,dev-only-token,http,localhost,8200,secret/myapp1/mongodb:uri?proto=http
+*/
+func queryProxy(ctx context.Context, q Query) (string, error) {
+	const me = "queryProxy"
 
-	if port != "" {
-		host += ":" + port
+	proto, host, secretName, errParse := parseProxyOptions(q)
+	if errParse != nil {
+		return "", fmt.Errorf("%w: %s: %w", ErrMalformedReference, me, errParse)
 	}
 
 	u, errJoin := url.JoinPath(proto+"://"+host, "/secret")
 	if errJoin != nil {
-		return "", errJoin
+		return "", fmt.Errorf("%w: %s: %w", ErrMalformedReference, me, errJoin)
 	}
 
 	requestBody := proxyPayload{
@@ -57,7 +44,7 @@ func queryProxy(debug bool, printf boilerplate.FuncPrintf,
 		return "", errBody
 	}
 
-	req, errReq := http.NewRequest("POST", u, bytes.NewBuffer(body))
+	req, errReq := http.NewRequestWithContext(ctx, "POST", u, bytes.NewBuffer(body))
 	if errReq != nil {
 		return "", errReq
 	}
@@ -80,22 +67,58 @@ func queryProxy(debug bool, printf boilerplate.FuncPrintf,
 	str := string(respBody)
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("%s: URL=%s bad status=%d: %v",
-			me, u, resp.StatusCode, str)
+		return "", statusError(me, u, resp.StatusCode, str)
 	}
 
 	var responseBody proxyPayload
 
 	errJSON := json.Unmarshal(respBody, &responseBody)
 
-	if debug {
-		printf("DEBUG %s: secret_name=%s secret_value=%s body=%s error=%v",
+	if q.Debug {
+		q.Printf("DEBUG %s: secret_name=%s secret_value=%s body=%s error=%v",
 			me, secretName, responseBody.SecretValue, str, errJSON)
 	}
 
 	return responseBody.SecretValue, errJSON
 }
 
+// parseProxyOptions returns proto, host and secret name.
+func parseProxyOptions(q Query) (string, string, string, error) {
+
+	if ref := q.Reference; ref.URI {
+		if ref.Location == "" {
+			return "", "", "", fmt.Errorf("missing secret name from URI path")
+		}
+		return ref.option("proto", "https"), ref.Host, ref.Location, nil
+	}
+
+	proxyOptions := q.Name
+
+	const fields = 4
+
+	options := strings.SplitN(proxyOptions, ",", fields)
+	if len(options) < fields {
+		return "", "", "", fmt.Errorf("bad proxy options, expecting %d fields - got: '%s'",
+			fields, proxyOptions)
+	}
+
+	// remove spaces
+	for i, s := range options {
+		options[i] = strings.TrimSpace(s)
+	}
+
+	proto := options[0]
+	host := options[1]
+	port := options[2]
+	secretName := options[3]
+
+	if port != "" {
+		host += ":" + port
+	}
+
+	return proto, host, secretName, nil
+}
+
 type proxyPayload struct {
 	SecretName  string `json:"secret_name,omitempty"`
 	SecretValue string `json:"secret_value,omitempty"`
@@ -0,0 +1,291 @@
+package secret
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+/*
+Reference is a parsed secret reference.
+
+References are written either in legacy syntax:
+
+	aws-secretsmanager:us-east-1:database:uri
+	vault::token,dev-only-token,http,localhost,8200,secret/myapp1/mongodb:uri
+
+Or in URI syntax, with percent-escaping for reserved characters:
+
+	secret+aws-secretsmanager://us-east-1/database?field=uri
+	secret+vault://localhost:8200/secret/myapp1/mongodb?field=uri&auth=token&token=dev-only-token&proto=http
+
+The URI scheme is "secret+" followed by the backend prefix. The leading '#'
+in prefix "#http" is dropped, hence "secret+http://". The URI authority is the
+region for aws stores, and host[:port] for network stores (vault, http, proxy).
+Query parameters "field" and "region" fill Field and Region, all other
+parameters are provided in Options.
+*/
+type Reference struct {
+	Backend  string     // backend prefix, like "aws-secretsmanager"
+	Region   string     // region; in URI form, defaults to the authority
+	Host     string     // URI authority, host[:port]; empty for legacy syntax
+	Location string     // store-specific secret location
+	Field    string     // optional field to extract from secret
+	Options  url.Values // backend options, only for URI syntax
+	URI      bool       // reference in URI syntax
+}
+
+// uriSchemePrefix starts URI syntax: secret+aws-secretsmanager://
+const uriSchemePrefix = "secret+"
+
+// defaultPrefixes lists prefixes for built-in backends.
+var defaultPrefixes = []string{
+	DefaultSecretsManagerPrefix,
+	DefaultParameterStorePrefix,
+	DefaultS3Prefix,
+	DefaultDynamoDbPrefix,
+	DefaultLambdaPrefix,
+	DefaultHTTPPrefix,
+	DefaultVaultPrefix,
+	DefaultProxyPrefix,
+	DefaultVaultTransitPrefix,
+}
+
+// ParseReference parses a secret reference for the built-in backends
+// with default prefixes. See Secret.ParseReference for custom prefixes.
+//
+// Errors wrap ErrMalformedReference.
+func ParseReference(name string) (Reference, error) {
+	return parseReferenceStrict(name, defaultPrefixes)
+}
+
+// ParseReference parses a secret reference for the backends
+// registered in the Secret.
+//
+// Errors wrap ErrMalformedReference.
+func (s *Secret) ParseReference(name string) (Reference, error) {
+	return parseReferenceStrict(name, s.prefixes())
+}
+
+func parseReferenceStrict(name string, prefixes []string) (Reference, error) {
+	ref, found, err := parseReference(name, prefixes)
+	if err != nil {
+		return ref, err
+	}
+	if !found {
+		return ref, fmt.Errorf("%w: no known backend prefix: %s", ErrMalformedReference, name)
+	}
+	return ref, nil
+}
+
+// parseReference parses a reference. found is false for literal values,
+// that do not start with any known prefix.
+func parseReference(name string, prefixes []string) (Reference, bool, error) {
+
+	if after, isURI := strings.CutPrefix(name, uriSchemePrefix); isURI {
+		if scheme, _, hasAuthority := strings.Cut(after, "://"); hasAuthority {
+			if backend, found := matchScheme(scheme, prefixes); found {
+				ref, err := parseURIReference(name, backend)
+				return ref, true, err
+			}
+		}
+	}
+
+	prefix := longestPrefix(name, prefixes)
+	if prefix == "" {
+		return Reference{}, false, nil
+	}
+
+	region, location, field, err := parseSecretName(prefix, name)
+	if err != nil {
+		return Reference{}, true, fmt.Errorf("%w: %w", ErrMalformedReference, err)
+	}
+
+	ref := Reference{
+		Backend:  prefix,
+		Region:   region,
+		Location: location,
+		Field:    field,
+	}
+
+	return ref, true, nil
+}
+
+func parseURIReference(name, backend string) (Reference, error) {
+	const me = "parseURIReference"
+
+	u, errURL := url.Parse(name)
+	if errURL != nil {
+		return Reference{}, fmt.Errorf("%w: %s: %w", ErrMalformedReference, me, errURL)
+	}
+
+	if u.User != nil {
+		return Reference{}, fmt.Errorf("%w: %s: userinfo is not supported, use query parameters: %s",
+			ErrMalformedReference, me, u.Redacted())
+	}
+
+	if u.Fragment != "" {
+		return Reference{}, fmt.Errorf("%w: %s: unexpected fragment, escape '#' as %%23: %s",
+			ErrMalformedReference, me, u.Redacted())
+	}
+
+	options := u.Query()
+
+	field := options.Get("field")
+	options.Del("field")
+
+	region := options.Get("region")
+	options.Del("region")
+	if region == "" {
+		region = u.Host
+	}
+
+	if len(options) == 0 {
+		options = nil
+	}
+
+	ref := Reference{
+		Backend:  backend,
+		Region:   region,
+		Host:     u.Host,
+		Location: strings.TrimPrefix(u.Path, "/"),
+		Field:    field,
+		Options:  options,
+		URI:      true,
+	}
+
+	return ref, nil
+}
+
+// matchScheme finds the prefix for URI scheme secret+<prefix>.
+func matchScheme(scheme string, prefixes []string) (string, bool) {
+	for _, p := range prefixes {
+		if strings.EqualFold(scheme, strings.TrimPrefix(p, "#")) {
+			return p, true
+		}
+	}
+	return "", false
+}
+
+// longestPrefix finds the longest prefix matching the name.
+func longestPrefix(name string, prefixes []string) string {
+	var prefix string
+	for _, p := range prefixes {
+		if len(p) > len(prefix) && strings.HasPrefix(name, p) {
+			prefix = p
+		}
+	}
+	return prefix
+}
+
+// referenceOptions splits the store-specific location into fields.
+// In legacy syntax, fields are separated by sep: location,opt1,opt2
+// In URI syntax, fields are the location followed by required options.
+func referenceOptions(q Query, sep string, names ...string) ([]string, error) {
+	fields := len(names) + 1
+
+	if !q.Reference.URI {
+		options := strings.SplitN(q.Name, sep, fields)
+		if len(options) < fields {
+			return nil, fmt.Errorf("expecting %d fields - got: '%s'", fields, q.Name)
+		}
+		return options, nil
+	}
+
+	if q.Name == "" {
+		return nil, fmt.Errorf("missing location from URI path")
+	}
+
+	options := make([]string, 0, fields)
+	options = append(options, q.Name)
+	for _, name := range names {
+		if !q.Reference.Options.Has(name) {
+			return nil, fmt.Errorf("missing URI option '%s'", name)
+		}
+		options = append(options, q.Reference.Options.Get(name))
+	}
+	return options, nil
+}
+
+// namedOptions splits the store-specific location from named options.
+// In legacy syntax, options follow the location separated by commas:
+// location,name1=value1,name2=value2
+// In URI syntax, options are the query parameters.
+func namedOptions(q Query) (string, url.Values, error) {
+	if q.Reference.URI {
+		return q.Name, q.Reference.Options, nil
+	}
+
+	location, list, found := strings.Cut(q.Name, ",")
+	if !found {
+		return location, nil, nil
+	}
+
+	options := url.Values{}
+	for opt := range strings.SplitSeq(list, ",") {
+		name, value, hasValue := strings.Cut(opt, "=")
+		name = strings.TrimSpace(name)
+		if !hasValue || name == "" {
+			return "", nil, fmt.Errorf("bad option, expecting name=value - got: '%s'", opt)
+		}
+		options.Add(name, strings.TrimSpace(value))
+	}
+
+	return location, options, nil
+}
+
+// trailingOptions splits trailing name=value segments with known names
+// from a legacy location that may itself contain commas:
+// bucket,key,with,commas,format=env
+func trailingOptions(location string, names []string) (string, url.Values) {
+	var options url.Values
+	for {
+		i := strings.LastIndexByte(location, ',')
+		if i < 0 {
+			return location, options
+		}
+		name, value, found := strings.Cut(location[i+1:], "=")
+		name = strings.TrimSpace(name)
+		if !found || !slices.Contains(names, name) {
+			return location, options
+		}
+		if options == nil {
+			options = url.Values{}
+		}
+		options.Add(name, strings.TrimSpace(value))
+		location = location[:i]
+	}
+}
+
+// option returns a backend option, or the default value if missing.
+func (r Reference) option(name, defaultValue string) string {
+	if v := r.Options.Get(name); v != "" {
+		return v
+	}
+	return defaultValue
+}
+
+// cacheKey identifies the raw secret, regardless of the field.
+func (r Reference) cacheKey() string {
+	key := r.Backend + ":" + r.Region + ":" + r.Location
+	if r.Host != "" && r.Host != r.Region {
+		key += ":" + r.Host
+	}
+	if len(r.Options) > 0 {
+		key += "?" + r.Options.Encode()
+	}
+	return key
+}
+
+// prefixes lists prefixes for registered backends.
+func (s *Secret) prefixes() []string {
+	s.backendsLock.RLock()
+	defer s.backendsLock.RUnlock()
+	list := make([]string, 0, len(s.backends))
+	for p := range s.backends {
+		list = append(list, p)
+	}
+	slices.Sort(list)
+	return list
+}
@@ -2,48 +2,204 @@ package secret
 
 import (
 	"context"
+	"crypto/md5"
+	"encoding/base64"
 	"fmt"
 	"io"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/udhos/boilerplate/boilerplate"
 )
 
-func queryS3(_ /*debug*/ bool, _ /*printf*/ boilerplate.FuncPrintf, getAwsConfig AwsConfigSolver, bucketAndKey string) (string, error) {
+/*
+aws-s3:region:bucket,key[,format=F][,version=V][,sse-key=K][,max-size=N][:field]
+
+secret+aws-s3://region/bucket/key[?format=F&version=V&sse-key=K&max-size=N&field=field]
+
+format: raw|env|properties|ini|toml, defaults to raw, or detected from key extension when a field is requested
+version: object VersionId
+sse-key: SSE-C customer key, base64-encoded 256-bit key
+max-size: maximum object size in bytes, defaults to 10 MiB
+
+Object formats env, properties, ini and toml are converted into JSON objects,
+for field extraction. Without an explicit format, references without a
+field return the object body unchanged:
+
+export DB_URI=aws-s3:us-east-1:bucketParameters,app7/prod.env:DB_URI
+export DB_URI=aws-s3:us-east-1:bucketParameters,app7/config.toml:db.uri
+*/
+func queryS3(ctx context.Context, q Query) (string, error) {
 	const me = "queryS3"
 
-	bucketName, objectKey, found := strings.Cut(bucketAndKey, ",")
-	if !found {
-		return "", fmt.Errorf("%s: bad bucket object, expecting 'bucket,key' - got: '%s'",
-			me, bucketAndKey)
+	opt, errOptions := parseS3Options(q)
+	if errOptions != nil {
+		return "", fmt.Errorf("%w: %s: %w", ErrMalformedReference, me, errOptions)
 	}
 
-	awsConfig, errAwsConfig := getAwsConfig.get()
+	awsConfig, errAwsConfig := q.AwsConfig(ctx)
 	if errAwsConfig != nil {
 		return "", errAwsConfig
 	}
 
 	s3client := s3.NewFromConfig(awsConfig, func(o *s3.Options) {
-		if endpoint := getAwsConfig.endpointURL(); endpoint != "" {
+		if endpoint := q.EndpointURL(); endpoint != "" {
 			o.BaseEndpoint = aws.String(endpoint)
 		}
 	})
 
 	input := &s3.GetObjectInput{
-		Bucket: aws.String(bucketName),
-		Key:    aws.String(objectKey),
+		Bucket: aws.String(opt.bucket),
+		Key:    aws.String(opt.key),
+	}
+
+	if opt.versionID != "" {
+		input.VersionId = aws.String(opt.versionID)
 	}
 
-	result, errS3 := s3client.GetObject(context.TODO(), input)
+	if opt.sseKey != nil {
+		sum := md5.Sum(opt.sseKey)
+		input.SSECustomerAlgorithm = aws.String("AES256")
+		input.SSECustomerKey = aws.String(base64.StdEncoding.EncodeToString(opt.sseKey))
+		input.SSECustomerKeyMD5 = aws.String(base64.StdEncoding.EncodeToString(sum[:]))
+	}
+
+	result, errS3 := s3client.GetObject(ctx, input)
 	if errS3 != nil {
 		return "", errS3
 	}
 
-	body, err := io.ReadAll(result.Body)
+	defer result.Body.Close()
+
+	if size := aws.ToInt64(result.ContentLength); size > opt.maxSize {
+		return "", fmt.Errorf("%s: object size=%d exceeds max-size=%d: %s/%s",
+			me, size, opt.maxSize, opt.bucket, opt.key)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(result.Body, opt.maxSize+1))
+	if err != nil {
+		return "", err
+	}
+
+	if int64(len(body)) > opt.maxSize {
+		return "", fmt.Errorf("%s: object exceeds max-size=%d: %s/%s",
+			me, opt.maxSize, opt.bucket, opt.key)
+	}
+
+	if opt.detected && q.Reference.Field == "" {
+		// format from key extension: converted only for field extraction
+		return string(body), nil
+	}
 
-	bodyStr := string(body)
+	value, errFormat := convertFormat(opt.format, string(body))
+	if errFormat != nil {
+		return "", fmt.Errorf("%s: %s/%s: %w", me, opt.bucket, opt.key, errFormat)
+	}
+
+	return value, nil
+}
+
+// s3Backend queries S3 objects.
+type s3Backend struct{}
+
+func (s3Backend) Query(ctx context.Context, q Query) (string, error) {
+	return queryS3(ctx, q)
+}
+
+// cacheKey keeps objects converted from the format detected from the key
+// extension apart from the raw body, since conversion applies only when
+// a field is requested.
+func (s3Backend) cacheKey(ref Reference) string {
+	key := ref.cacheKey()
+	if ref.Field == "" {
+		return key
+	}
+	opt, err := parseS3Options(Query{Name: ref.Location, Reference: ref})
+	if err != nil || !opt.detected {
+		return key
+	}
+	return key + "#" + opt.format
+}
+
+// defaultS3MaxSize guards against reading huge objects into memory.
+const defaultS3MaxSize = 10 * 1024 * 1024
+
+type s3Options struct {
+	bucket    string
+	key       string
+	format    string
+	detected  bool // format detected from key extension, rather than format option
+	versionID string
+	sseKey    []byte
+	maxSize   int64
+}
+
+// s3OptionNames lists named options. In legacy syntax, since object keys
+// may contain commas, only trailing name=value segments with these names
+// are taken as options.
+var s3OptionNames = []string{"format", "version", "sse-key", "max-size"}
+
+func parseS3Options(q Query) (s3Options, error) {
+
+	bucketAndKey := q.Name
+	options := q.Reference.Options
+
+	// legacy: aws-s3:region:bucket,key
+	// URI:    secret+aws-s3://region/bucket/key
+	sep := ","
+	if q.Reference.URI {
+		sep = "/"
+		for name := range options {
+			if !slices.Contains(s3OptionNames, name) {
+				return s3Options{}, fmt.Errorf("unknown option '%s', expecting one of %v", name, s3OptionNames)
+			}
+		}
+	} else {
+		bucketAndKey, options = trailingOptions(bucketAndKey, s3OptionNames)
+	}
+
+	bucketName, objectKey, found := strings.Cut(bucketAndKey, sep)
+	if !found {
+		return s3Options{}, fmt.Errorf("bad bucket object, expecting 'bucket%skey' - got: '%s'",
+			sep, bucketAndKey)
+	}
+
+	opt := s3Options{
+		bucket:    bucketName,
+		key:       objectKey,
+		format:    options.Get("format"),
+		versionID: options.Get("version"),
+		maxSize:   defaultS3MaxSize,
+	}
+
+	if opt.format == "" {
+		opt.format = detectFormat(objectKey)
+		opt.detected = opt.format != "raw"
+	}
+	if !slices.Contains(formats, opt.format) {
+		return opt, fmt.Errorf("unknown format '%s', expecting one of %v", opt.format, formats)
+	}
+
+	if k := options.Get("sse-key"); k != "" {
+		key, errKey := base64.StdEncoding.DecodeString(k)
+		if errKey != nil {
+			return opt, fmt.Errorf("bad sse-key: %w", errKey)
+		}
+		if len(key) != 32 {
+			return opt, fmt.Errorf("bad sse-key: expecting 256-bit key, got %d bits", 8*len(key))
+		}
+		opt.sseKey = key
+	}
+
+	if m := options.Get("max-size"); m != "" {
+		maxSize, errSize := strconv.ParseInt(m, 10, 64)
+		if errSize != nil || maxSize < 1 {
+			return opt, fmt.Errorf("bad max-size: '%s'", m)
+		}
+		opt.maxSize = maxSize
+	}
 
-	return bodyStr, err
+	return opt, nil
 }
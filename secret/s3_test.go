@@ -0,0 +1,122 @@
+package secret
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+type s3OptionsTestCase struct {
+	name          string
+	reference     string
+	expectedError bool
+	bucket        string
+	key           string
+	format        string
+	versionID     string
+	maxSize       int64
+}
+
+var s3OptionsTestTable = []s3OptionsTestCase{
+	{"legacy", "aws-s3:us-east-1:bucket,app/config.yaml:uri", expectOk, "bucket", "app/config.yaml", "raw", "", defaultS3MaxSize},
+	{"legacy detect", "aws-s3:us-east-1:bucket,app/prod.env:DB_URI", expectOk, "bucket", "app/prod.env", "env", "", defaultS3MaxSize},
+	{"legacy comma key", "aws-s3:us-east-1:bucket,a,b=c,d", expectOk, "bucket", "a,b=c,d", "raw", "", defaultS3MaxSize},
+	{"legacy options", "aws-s3:us-east-1:bucket,a,b,format=toml,version=v1,max-size=100", expectOk, "bucket", "a,b", "toml", "v1", 100},
+	{"legacy bad format", "aws-s3:us-east-1:bucket,key,format=xml", expectError, "", "", "", "", 0},
+	{"legacy bad max-size", "aws-s3:us-east-1:bucket,key,max-size=0", expectError, "", "", "", "", 0},
+	{"legacy bad sse-key", "aws-s3:us-east-1:bucket,key,sse-key=YWJj", expectError, "", "", "", "", 0},
+	{"legacy missing key", "aws-s3:us-east-1:bucket", expectError, "", "", "", "", 0},
+	{"uri", "secret+aws-s3://us-east-1/bucket/app/app.properties?version=v2&field=db.uri", expectOk, "bucket", "app/app.properties", "properties", "v2", defaultS3MaxSize},
+	{"uri raw", "secret+aws-s3://us-east-1/bucket/app/prod.env?format=raw", expectOk, "bucket", "app/prod.env", "raw", "", defaultS3MaxSize},
+	{"uri unknown option", "secret+aws-s3://us-east-1/bucket/key?versionId=v2", expectError, "", "", "", "", 0},
+}
+
+func TestParseS3Options(t *testing.T) {
+	for _, data := range s3OptionsTestTable {
+		t.Run(data.name, func(t *testing.T) {
+			ref, errRef := ParseReference(data.reference)
+			if errRef != nil {
+				t.Fatalf("parse reference: %v", errRef)
+			}
+			opt, err := parseS3Options(Query{Region: ref.Region, Name: ref.Location, Reference: ref})
+			if data.expectedError {
+				if err == nil {
+					t.Errorf("expected error, got: %+v", opt)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if opt.bucket != data.bucket || opt.key != data.key || opt.format != data.format ||
+				opt.versionID != data.versionID || opt.maxSize != data.maxSize {
+				t.Errorf("unexpected options: %+v", opt)
+			}
+		})
+	}
+}
+
+func TestParseS3OptionsSSE(t *testing.T) {
+	const key = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=" // 32 bytes
+	ref, errRef := ParseReference("aws-s3:us-east-1:bucket,key,sse-key=" + key)
+	if errRef != nil {
+		t.Fatalf("parse reference: %v", errRef)
+	}
+	opt, err := parseS3Options(Query{Name: ref.Location, Reference: ref})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(opt.sseKey) != 32 {
+		t.Errorf("expected 32-byte key, got %d", len(opt.sseKey))
+	}
+}
+
+func TestS3DetectedFormat(t *testing.T) {
+
+	const body = "[db]\nuri = \"mongodb://s3\"\n"
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/bucket/app/config.toml" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		io.WriteString(w, body)
+	}))
+	defer ts.Close()
+
+	creds := aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "SECRET"}
+
+	secret := New(Options{AwsConfigSource: &staticAwsConfig{credentials: creds, endpoint: ts.URL}})
+
+	table := []struct {
+		name     string
+		ref      string
+		expected string
+	}{
+		{"raw without field", "aws-s3:us-east-1:bucket,app/config.toml", body},
+		{"converted for field", "aws-s3:us-east-1:bucket,app/config.toml:db.uri", "mongodb://s3"},
+		{"raw again from cache", "aws-s3:us-east-1:bucket,app/config.toml", body},
+		{"explicit format", "aws-s3:us-east-1:bucket,app/config.toml,format=toml", `{"db":{"uri":"mongodb://s3"}}`},
+		{"converted again from cache", "aws-s3:us-east-1:bucket,app/config.toml:db.uri", "mongodb://s3"},
+		{"custom prefix", "my-s3:us-east-1:bucket,app/config.toml:db.uri", "mongodb://s3"},
+		{"custom prefix raw", "my-s3:us-east-1:bucket,app/config.toml", body},
+	}
+
+	// the same backend under another prefix
+	secret.RegisterBackend("my-s3", s3Backend{})
+
+	for _, data := range table {
+		t.Run(data.name, func(t *testing.T) {
+			value, err := secret.RetrieveWithError(data.ref)
+			if err != nil {
+				t.Fatalf("retrieve: %v", err)
+			}
+			if value != data.expected {
+				t.Errorf("expected=%q got=%q", data.expected, value)
+			}
+		})
+	}
+}
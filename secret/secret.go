@@ -2,14 +2,15 @@
 package secret
 
 import (
+	"context"
 	"fmt"
 	"log"
-	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"gopkg.in/yaml.v3"
+	"golang.org/x/sync/singleflight"
 
 	"github.com/udhos/boilerplate/awsconfig"
 	"github.com/udhos/boilerplate/boilerplate"
@@ -17,19 +18,33 @@ import (
 
 // Options provide optional parameters for client.
 type Options struct {
-	Debug                bool
-	Printf               boilerplate.FuncPrintf // defaults to log.Printf
-	PrefixSecretsManager string                 // defaults to "aws-secretsmanager"
-	PrefixParameterStore string                 // defaults to "aws-parameterstore"
-	PrefixS3             string                 // defaults to "aws-s3"
-	PrefixDynamoDb       string                 // defaults to "aws-dynamodb"
-	PrefixLambda         string                 // defaults to "aws-lambda"
-	PrefixHTTP           string                 // defaults to "#http"
-	PrefixVault          string                 // defaults to "vault"
-	PrefixProxy          string                 // defaults to "proxy"
-	CrashOnQueryError    bool                   // require secret
-	CacheTTLSeconds      int                    // cache TTL in seconds: -1=noCache 0=useDefault (60)
-	AwsConfigSource      AwsConfigSolver
+	Debug                  bool
+	Printf                 boilerplate.FuncPrintf // defaults to log.Printf
+	PrefixSecretsManager   string                 // defaults to "aws-secretsmanager"
+	PrefixParameterStore   string                 // defaults to "aws-parameterstore"
+	PrefixS3               string                 // defaults to "aws-s3"
+	PrefixDynamoDb         string                 // defaults to "aws-dynamodb"
+	PrefixLambda           string                 // defaults to "aws-lambda"
+	PrefixHTTP             string                 // defaults to "#http"
+	PrefixVault            string                 // defaults to "vault"
+	PrefixProxy            string                 // defaults to "proxy"
+	PrefixVaultTransit     string                 // defaults to "vault-transit"
+	CrashOnQueryError      bool                   // require secret: exit on error, only for FailReturnName policy
+	FailurePolicy          FailurePolicy          // what Retrieve returns on error: defaults to FailReturnName
+	FailureDefault         string                 // value returned on error for FailReturnDefault policy
+	OnError                ErrorHandler           // hook invoked on error for FailHook policy
+	Strict                 bool                   // names starting with a known prefix that fail to parse are errors, rather than literal values
+	CacheTTLSeconds        int                    // cache TTL in seconds: -1=noCache 0=useDefault (60)
+	CacheMaxEntries        int                    // maximum entries in default cache: -1=unlimited 0=useDefault (1000)
+	Cache                  Cache                  // defaults to LRU cache with CacheMaxEntries
+	QueryTimeoutSeconds    int                    // timeout for each backend query in seconds: -1=noTimeout 0=useDefault (30)
+	BatchConcurrency       int                    // concurrent queries for RetrieveMany: 0=useDefault (10)
+	StaleIfErrorSeconds    int                    // serve cached value up to this many seconds past TTL when store fails: 0=disabled
+	ErrorBackoffSeconds    int                    // after store failure, skip queries for this many seconds, doubled on repeated failures: 0=disabled
+	ErrorBackoffMaxSeconds int                    // maximum error backoff in seconds: 0=useDefault (300)
+	OnLeaseExpired         LeaseHandler           // hook invoked when a vault dynamic secret lease can no longer be renewed
+	AwsConfigSource        AwsConfigSolver
+	Backends               map[string]Backend // custom backends keyed by prefix
 }
 
 // Define default prefixes for Secrets Manager and Parameter Store.
@@ -42,12 +57,28 @@ const (
 	DefaultHTTPPrefix           = "#http"
 	DefaultVaultPrefix          = "vault"
 	DefaultProxyPrefix          = "proxy"
+	DefaultVaultTransitPrefix   = "vault-transit"
 )
 
 // Secret holds context information for retrieving secrets.
+// Secret is safe for concurrent use by multiple goroutines.
 type Secret struct {
-	options Options
-	cache   map[string]secret
+	options      Options
+	cache        Cache
+	failures     map[string]failure
+	failuresLock sync.Mutex
+	backends     map[string]Backend
+	backendsLock sync.RWMutex
+	group        singleflight.Group
+
+	vaultSessions     map[string]*vaultSession // see vaultSessionKey
+	vaultSessionsLock sync.Mutex
+	vaultMounts       sync.Map               // mount detection, see Query.vaultMount
+	vaultLeases       map[string]*vaultLease // dynamic secrets, keyed by Reference.cacheKey
+	vaultLeasesLock   sync.Mutex
+
+	httpClients  sync.Map // httpTLS => *http.Client
+	oauth2Tokens sync.Map // hash of endpoint and credentials => *oauth2Token
 }
 
 // New creates a Secret context for retrieving secrets.
@@ -93,34 +124,130 @@ func New(opt Options) *Secret {
 		opt.PrefixProxy = DefaultProxyPrefix
 	}
 
+	if opt.PrefixVaultTransit == "" {
+		opt.PrefixVaultTransit = DefaultVaultTransitPrefix
+	}
+
+	if opt.FailurePolicy == FailHook && opt.OnError == nil {
+		panic("FailHook policy requires OnError")
+	}
+
 	if opt.CacheTTLSeconds < 0 {
 		opt.CacheTTLSeconds = 0 // disable cache
 	} else if opt.CacheTTLSeconds == 0 {
 		opt.CacheTTLSeconds = 60 // default 60 seconds
 	}
 
-	return &Secret{
-		options: opt,
-		cache:   map[string]secret{},
+	if opt.CacheMaxEntries < 0 {
+		opt.CacheMaxEntries = 0 // unlimited
+	} else if opt.CacheMaxEntries == 0 {
+		opt.CacheMaxEntries = 1000 // default 1000 entries
+	}
+
+	if opt.Cache == nil {
+		opt.Cache = NewLRUCache(opt.CacheMaxEntries)
+	}
+
+	if opt.StaleIfErrorSeconds < 0 {
+		opt.StaleIfErrorSeconds = 0
+	}
+
+	if opt.ErrorBackoffSeconds < 0 {
+		opt.ErrorBackoffSeconds = 0
+	}
+
+	if opt.ErrorBackoffMaxSeconds <= 0 {
+		opt.ErrorBackoffMaxSeconds = 300 // default 5 minutes
+	}
+
+	if opt.BatchConcurrency < 1 {
+		opt.BatchConcurrency = 10 // default 10 concurrent queries
+	}
+
+	if opt.QueryTimeoutSeconds < 0 {
+		opt.QueryTimeoutSeconds = 0 // disable timeout
+	} else if opt.QueryTimeoutSeconds == 0 {
+		opt.QueryTimeoutSeconds = 30 // default 30 seconds
+	}
+
+	s := &Secret{
+		options:  opt,
+		cache:    opt.Cache,
+		failures: map[string]failure{},
+		backends: map[string]Backend{},
+	}
+
+	s.RegisterBackend(opt.PrefixSecretsManager, batchBackend{querySecret, querySecretBatch})
+	s.RegisterBackend(opt.PrefixParameterStore, batchBackend{queryParameter, queryParameterBatch})
+	s.RegisterBackend(opt.PrefixS3, s3Backend{})
+	s.RegisterBackend(opt.PrefixDynamoDb, batchBackend{queryDynamoDb, queryDynamoDbBatch})
+	s.RegisterBackend(opt.PrefixLambda, BackendFunc(queryLambda))
+	s.RegisterBackend(opt.PrefixHTTP, BackendFunc(queryHTTP))
+	s.RegisterBackend(opt.PrefixVault, BackendFunc(queryVault))
+	s.RegisterBackend(opt.PrefixProxy, BackendFunc(queryProxy))
+	s.RegisterBackend(opt.PrefixVaultTransit, BackendFunc(queryVaultTransit))
+
+	for prefix, b := range opt.Backends {
+		s.RegisterBackend(prefix, b)
+	}
+
+	return s
+}
+
+// RegisterBackend adds a backend for secrets named with the prefix.
+// A backend registered for an existing prefix replaces the previous one.
+// When multiple prefixes match a name, the longest prefix wins.
+//
+// Example:
+//
+//	s.RegisterBackend("my-store", secret.BackendFunc(func(ctx context.Context, q secret.Query) (string, error) {
+//		return lookup(q.Region, q.Name)
+//	}))
+//
+//	value := s.Retrieve("my-store:us-east-1:db:uri")
+func (s *Secret) RegisterBackend(prefix string, backend Backend) {
+	if prefix == "" {
+		panic("RegisterBackend: empty prefix")
+	}
+	if backend == nil {
+		panic("RegisterBackend: nil backend")
+	}
+	s.backendsLock.Lock()
+	s.backends[prefix] = backend
+	s.backendsLock.Unlock()
+}
+
+// resolve parses the name and finds its backend.
+// found is false for literal values, that do not reference any backend.
+func (s *Secret) resolve(name string) (Reference, Backend, bool, error) {
+	ref, found, err := parseReference(name, s.prefixes())
+	if !found {
+		return ref, nil, false, nil
 	}
+
+	s.backendsLock.RLock()
+	backend := s.backends[ref.Backend]
+	s.backendsLock.RUnlock()
+
+	return ref, backend, true, err
 }
 
 // Retrieve fetches a secret.
-// If an error is found, only crashes if CrashOnQueryError is set.
+// If an error is found, the result depends on Options.FailurePolicy.
 // name: aws-secretsmanager:region:name:json_field
 func (s *Secret) Retrieve(name string) string {
+	return s.RetrieveContext(context.Background(), name)
+}
+
+// RetrieveContext is like Retrieve, but the context bounds the backend query.
+func (s *Secret) RetrieveContext(ctx context.Context, name string) string {
 	const me = "Secret.Retrieve"
 
-	value, err := s.RetrieveWithError(name)
+	value, err := s.RetrieveWithErrorContext(ctx, name)
 	if err != nil {
-		s.options.Printf("%s: error: name='%s': %v",
-			me, name, err)
-		if s.options.CrashOnQueryError {
-			s.options.Printf("%s: error: crashing on error: name='%s': %v",
-				me, name, err)
-			os.Exit(1)
-		}
-		return name
+		s.options.Printf("%s: error: name='%s' policy=%s: %v",
+			me, name, s.options.FailurePolicy, err)
+		return s.fail(name, err)
 	}
 
 	return value
@@ -129,51 +256,36 @@ func (s *Secret) Retrieve(name string) string {
 // RetrieveWithError fetches a secret.
 // name: aws-secretsmanager:region:name:json_field
 func (s *Secret) RetrieveWithError(name string) (string, error) {
+	return s.RetrieveWithErrorContext(context.Background(), name)
+}
+
+// RetrieveWithErrorContext is like RetrieveWithError, but the context bounds the backend query.
+func (s *Secret) RetrieveWithErrorContext(ctx context.Context, name string) (string, error) {
 
-	var err error
-
-	switch {
-	case strings.HasPrefix(name, s.options.PrefixSecretsManager):
-		name, err = s.query(querySecret, s.options.PrefixSecretsManager, name)
-	case strings.HasPrefix(name, s.options.PrefixParameterStore):
-		name, err = s.query(queryParameter, s.options.PrefixParameterStore, name)
-	case strings.HasPrefix(name, s.options.PrefixS3):
-		name, err = s.query(queryS3, s.options.PrefixS3, name)
-	case strings.HasPrefix(name, s.options.PrefixDynamoDb):
-		name, err = s.query(queryDynamoDb, s.options.PrefixDynamoDb, name)
-	case strings.HasPrefix(name, s.options.PrefixLambda):
-		name, err = s.query(queryLambda, s.options.PrefixLambda, name)
-	case strings.HasPrefix(name, s.options.PrefixHTTP):
-		name, err = s.query(queryHTTP, s.options.PrefixHTTP, name)
-	case strings.HasPrefix(name, s.options.PrefixVault):
-		name, err = s.query(queryVault, s.options.PrefixVault, name)
-	case strings.HasPrefix(name, s.options.PrefixProxy):
-		name, err = s.query(queryProxy, s.options.PrefixProxy, name)
-	}
-
-	return name, err
+	return s.query(ctx, name, false)
 }
 
-// querySimple retrieves a secret.
-// If an error is found, only crashes if CrashOnQueryError is set.
-// key: aws-secretsmanager:region:name:json_field
-func (s *Secret) querySimple(q queryFunc, prefix, key string) string {
-	const me = "querySimple"
-
-	value, errQuery := s.query(q, prefix, key)
-
-	if errQuery != nil {
-		s.options.Printf("%s: error: key='%s': %v",
-			me, key, errQuery)
-		if s.options.CrashOnQueryError {
-			s.options.Printf("%s: error: crashing on error: key='%s': %v",
-				me, key, errQuery)
-			os.Exit(1)
-		}
-		return key
+// RetrieveMap fetches a secret holding a JSON or YAML object, like a
+// Parameter Store hierarchy, and decodes it into a map.
+// Values that are not strings are returned as JSON.
+//
+// Example:
+//
+//	params, err := s.RetrieveMap(ctx, "aws-parameterstore:us-east-1:/myapp/prod/")
+func (s *Secret) RetrieveMap(ctx context.Context, name string) (map[string]string, error) {
+	const me = "Secret.RetrieveMap"
+
+	value, err := s.RetrieveWithErrorContext(ctx, name)
+	if err != nil {
+		return nil, err
 	}
 
-	return value
+	m, errMap := decodeMap(value)
+	if errMap != nil {
+		return nil, fmt.Errorf("%s: name='%s': %w", me, name, errMap)
+	}
+
+	return m, nil
 }
 
 func parseSecretName(prefix, name string) (string, string, string, error) {
@@ -211,20 +323,29 @@ func parseSecretName(prefix, name string) (string, string, string, error) {
 }
 
 // query retrieves a secret.
+// If refresh is set, the cache is bypassed and updated with the value from the store.
 // key: aws-secretsmanager:region:name:json_field
-func (s *Secret) query(q queryFunc, prefix, key string) (string, error) {
+func (s *Secret) query(ctx context.Context, key string, refresh bool) (string, error) {
 	const me = "query"
 
 	//
 	// parse key: aws-secretsmanager:region:name:json_field
 	//
 
-	region, secretName, jsonField, errParse := parseSecretName(prefix, key)
+	ref, q, found, errParse := s.resolve(key)
+	if !found {
+		return key, nil // literal value
+	}
 	if errParse != nil {
 		s.options.Printf("%s: parse secret error: %v", me, errParse)
+		if s.options.Strict {
+			return key, errParse
+		}
 		return key, nil
 	}
 
+	jsonField := ref.Field
+
 	if s.options.Debug {
 		s.options.Printf("%s: key='%s' json_field=%s",
 			me, key, jsonField)
@@ -236,7 +357,7 @@ func (s *Secret) query(q queryFunc, prefix, key string) (string, error) {
 
 	begin := time.Now()
 
-	secretString, errSecret := s.retrieve(q, region, secretName, jsonField)
+	secretString, errSecret := s.retrieve(ctx, q, ref, refresh)
 
 	if s.options.Debug {
 		s.options.Printf("%s: query: key='%s': elapsed: %v",
@@ -249,6 +370,16 @@ func (s *Secret) query(q queryFunc, prefix, key string) (string, error) {
 		return key, errSecret
 	}
 
+	return s.extract(key, ref, secretString)
+}
+
+// extract returns the field from the raw secret, or the raw secret if
+// the reference has no field.
+func (s *Secret) extract(key string, ref Reference, secretString string) (string, error) {
+	const me = "extract"
+
+	jsonField := ref.Field
+
 	if jsonField == "" {
 		// return scalar (non-JSON) secret
 		if s.options.Debug {
@@ -262,16 +393,16 @@ func (s *Secret) query(q queryFunc, prefix, key string) (string, error) {
 	// extract field from secret in JSON
 	//
 
-	value := map[string]string{}
-
-	errJSON := yaml.Unmarshal([]byte(secretString), &value)
+	fieldValue, found, errJSON := extractField(secretString, jsonField)
 	if errJSON != nil {
 		s.options.Printf("%s: json error: key='%s': %v",
 			me, key, errJSON)
 		return secretString, errJSON
 	}
 
-	fieldValue := value[jsonField]
+	if !found {
+		return key, fmt.Errorf("%w: key='%s' json_field=%s", ErrFieldMissing, key, jsonField)
+	}
 
 	if s.options.Debug {
 		s.options.Printf("%s: key='%s' json_field=%s: value=%s",
@@ -282,7 +413,7 @@ func (s *Secret) query(q queryFunc, prefix, key string) (string, error) {
 }
 
 //
-// We only cache secrets with JSON fields:
+// We cache raw secrets, keyed by prefix, region and name:
 //
 //     {"uri":"mongodb://127.0.0.2:27017", "database":"bogus"}
 //
@@ -293,75 +424,155 @@ func (s *Secret) query(q queryFunc, prefix, key string) (string, error) {
 //     export MONGO_DATABASE=aws-secretsmanager:us-east-1:mongo:database
 //
 
-type secret struct {
-	value   string
-	created time.Time
-}
-
-func (s *Secret) retrieve(q queryFunc, region, secretName, field string) (string, error) {
+func (s *Secret) retrieve(ctx context.Context, q Backend, ref Reference, refresh bool) (string, error) {
 	const me = "Secret.retrieve"
 
-	var cacheKey string
-	var secretString string
+	cacheKey := backendCacheKey(q, ref)
 
-	if field != "" {
+	if !refresh {
 		//
-		// check cache, only for JSON values
+		// check cache
 		//
-		cacheKey = region + ":" + secretName
-		cached, found := s.cache[cacheKey]
-		if found {
-			// cache hit
-			elapsed := time.Since(cached.created)
-			ttl := time.Second * time.Duration(s.options.CacheTTLSeconds)
-			if elapsed < ttl {
-				// live entry
-				secretString = cached.value
-				if s.options.Debug {
-					s.options.Printf("%s: from cache: %s=%s (elapsed=%s TTL=%s)",
-						me, cacheKey, secretString, elapsed, ttl)
-				}
-				return secretString, nil
-			}
-			// stale entry
-			delete(s.cache, cacheKey)
+		if secretString, found := s.cacheGet(cacheKey); found {
+			return secretString, nil
 		}
 	}
 
 	//
-	// field not provided || cache miss || stale cache entry
+	// refresh || cache miss || stale cache entry
 	//
 
 	//
-	// retrieve from secrets manager
+	// retrieve from store, coalescing concurrent queries for the same secret
 	//
-	s.options.AwsConfigSource.setRegion(region)
 
-	value, errSecret := q(s.options.Debug, s.options.Printf, s.options.AwsConfigSource, secretName)
+	if errBackoff := s.backoff(cacheKey); errBackoff != nil {
+		return s.staleIfError(cacheKey, errBackoff)
+	}
+
+	ch := s.group.DoChan(cacheKey, func() (any, error) {
+		shared, cancel := s.sharedContext(ctx)
+		defer cancel()
+		value, errSecret := s.fetch(shared, q, ref)
+		if errSecret != nil {
+			// detached from callers: failures, timeouts included, come from the store
+			s.recordFailure(cacheKey, errSecret)
+			return value, errSecret
+		}
+		s.clearFailure(cacheKey)
+		s.cachePut(cacheKey, value)
+		return value, nil
+	})
+
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case result := <-ch:
+		if result.Err != nil {
+			s.options.Printf("%s: secret query error: %v", me, result.Err)
+			return s.staleIfError(cacheKey, result.Err)
+		}
+		if result.Shared && s.options.Debug {
+			s.options.Printf("%s: shared query: %s", me, cacheKey)
+		}
+		return result.Val.(string), nil
+	}
+}
+
+// fetch queries the backend.
+func (s *Secret) fetch(ctx context.Context, q Backend, ref Reference) (string, error) {
+	const me = "Secret.fetch"
+
+	ctx, cancel := s.queryContext(ctx)
+	defer cancel()
+
+	value, errSecret := q.Query(ctx, s.newQuery(ref))
 	if errSecret != nil {
-		s.options.Printf("%s: secret query error: %v", me, errSecret)
-		return value, errSecret
+		return value, &QueryError{Prefix: ref.Backend, Region: ref.Region, Err: classifyError(errSecret)}
 	}
-	secretString = value
 
 	//
 	// retrieved value from service
 	//
 	if s.options.Debug {
-		s.options.Printf("%s: from store: %s=%s", me, secretName, secretString)
+		s.options.Printf("%s: from store: %s=%s", me, ref.Location, value)
 	}
 
-	if field != "" {
-		//
-		// save to cache
-		//
-		s.cache[cacheKey] = secret{
-			value:   secretString,
-			created: time.Now(),
-		}
+	return value, nil
+}
+
+// sharedContext detaches the query shared by concurrent callers from the
+// caller that started it: a caller giving up must not fail other waiters.
+// The query is then bounded by QueryTimeoutSeconds, or by sharedQueryTimeout
+// when it is disabled, so that a hung backend does not pin the query forever.
+func (s *Secret) sharedContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	shared := context.WithoutCancel(ctx)
+	if s.options.QueryTimeoutSeconds > 0 {
+		return context.WithCancel(shared)
+	}
+	return context.WithTimeout(shared, sharedQueryTimeout)
+}
+
+// sharedQueryTimeout bounds shared queries when QueryTimeoutSeconds is disabled.
+const sharedQueryTimeout = 5 * time.Minute
+
+// queryContext bounds a backend query with Options.QueryTimeoutSeconds.
+func (s *Secret) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.options.QueryTimeoutSeconds > 0 {
+		timeout := time.Second * time.Duration(s.options.QueryTimeoutSeconds)
+		return context.WithTimeout(ctx, timeout)
 	}
+	return context.WithCancel(ctx)
+}
 
-	return secretString, nil
+func (s *Secret) newQuery(ref Reference) Query {
+	return Query{
+		Debug:     s.options.Debug,
+		Printf:    s.options.Printf,
+		Region:    ref.Region,
+		Name:      ref.Location,
+		Reference: ref,
+		awsConfig: s.options.AwsConfigSource,
+		secret:    s,
+	}
+}
+
+func (s *Secret) cacheGet(cacheKey string) (string, bool) {
+	const me = "Secret.cacheGet"
+
+	cached, found := s.cache.Get(cacheKey)
+	if !found {
+		return "", false
+	}
+
+	// cache hit
+	if !cached.Fresh(time.Now()) {
+		// stale entry, kept while it might be served on store failure
+		return "", false
+	}
+
+	// live entry
+	if s.options.Debug {
+		s.options.Printf("%s: from cache: %s=%s (elapsed=%s TTL=%s)",
+			me, cacheKey, cached.Value, time.Since(cached.Created), cached.TTL)
+	}
+
+	return cached.Value, true
+}
+
+func (s *Secret) cachePut(cacheKey, value string) {
+	ttl := time.Second * time.Duration(s.options.CacheTTLSeconds)
+	keep := ttl + s.staleWindow()
+	if keep <= 0 {
+		return // cache disabled
+	}
+	now := time.Now()
+	s.cache.Put(cacheKey, CacheEntry{
+		Value:   value,
+		Created: now,
+		TTL:     ttl,
+		Expires: now.Add(keep),
+	})
 }
 
 // AwsConfigSource implements AwsConfigSolver.
@@ -369,8 +580,10 @@ type AwsConfigSource struct {
 	AwsConfigOptions awsconfig.Options
 }
 
-func (s *AwsConfigSource) get() (aws.Config, error) {
-	output, err := awsconfig.AwsConfig(s.AwsConfigOptions)
+func (s *AwsConfigSource) get(ctx context.Context, region string) (aws.Config, error) {
+	opt := s.AwsConfigOptions // copy, since the source is shared between queries
+	opt.Region = region
+	output, err := awsconfig.AwsConfigContext(ctx, opt)
 	return output.AwsConfig, err
 }
 
@@ -378,15 +591,8 @@ func (s *AwsConfigSource) endpointURL() string {
 	return s.AwsConfigOptions.EndpointURL
 }
 
-func (s *AwsConfigSource) setRegion(region string) {
-	s.AwsConfigOptions.Region = region
-}
-
 // AwsConfigSolver provides aws configuration.
 type AwsConfigSolver interface {
-	get() (aws.Config, error)
+	get(ctx context.Context, region string) (aws.Config, error)
 	endpointURL() string
-	setRegion(region string)
 }
-
-type queryFunc func(debug bool, printf boilerplate.FuncPrintf, getAwsConfig AwsConfigSolver, name string) (string, error)
@@ -2,32 +2,263 @@ package secret
 
 import (
 	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
 	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
-	"github.com/udhos/boilerplate/boilerplate"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager/types"
+	"github.com/aws/smithy-go"
 )
 
-func querySecret(_ /*debug*/ bool, _ /*printf*/ boilerplate.FuncPrintf, getAwsConfig AwsConfigSolver, secretName string) (string, error) {
+/*
+aws-secretsmanager:region:secret_id[,stage=label][,version=version_id][,binary=base64|raw][:field]
 
-	awsConfig, errAwsConfig := getAwsConfig.get()
+secret+aws-secretsmanager://region/secret_id[?stage=label&version=version_id&binary=base64|raw&field=field]
+
+secret_id is the secret name or the full secret ARN. The ARN region overrides
+the reference region. Since ARNs contain ':', legacy syntax requires another separator:
+
+export DB_URI='aws-secretsmanager||arn:aws:secretsmanager:us-east-1:123456789012:secret:db-AbCdEf,stage=AWSPREVIOUS|uri'
+export DB_URI='secret+aws-secretsmanager:///arn:aws:secretsmanager:us-east-1:123456789012:secret:db-AbCdEf?stage=AWSPREVIOUS&field=uri'
+
+stage defaults to AWSCURRENT, unless version is given.
+Binary secrets are returned base64-encoded, unless binary=raw.
+*/
+func querySecret(ctx context.Context, q Query) (string, error) {
+	const me = "querySecret"
+
+	opt, errOptions := parseSecretsManagerOptions(q)
+	if errOptions != nil {
+		return "", fmt.Errorf("%w: %s: %w", ErrMalformedReference, me, errOptions)
+	}
+
+	q.Region = opt.region
+
+	awsConfig, errAwsConfig := q.AwsConfig(ctx)
 	if errAwsConfig != nil {
 		return "", errAwsConfig
 	}
 
 	sm := secretsmanager.NewFromConfig(awsConfig, func(o *secretsmanager.Options) {
-		if endpoint := getAwsConfig.endpointURL(); endpoint != "" {
+		if endpoint := q.EndpointURL(); endpoint != "" {
 			o.BaseEndpoint = aws.String(endpoint)
 		}
 	})
 
 	input := &secretsmanager.GetSecretValueInput{
-		SecretId:     aws.String(secretName),
-		VersionStage: aws.String("AWSCURRENT"), // VersionStage defaults to AWSCURRENT if unspecified
+		SecretId: aws.String(opt.secretID),
+	}
+	if opt.versionID != "" {
+		input.VersionId = aws.String(opt.versionID)
 	}
-	result, errSecret := sm.GetSecretValue(context.TODO(), input)
+	if opt.stage != "" {
+		input.VersionStage = aws.String(opt.stage)
+	}
+
+	result, errSecret := sm.GetSecretValue(ctx, input)
 	if errSecret != nil {
 		return "", errSecret
 	}
-	return *result.SecretString, nil
+
+	return secretValue(me, opt, result.SecretString, result.SecretBinary)
+}
+
+type secretsManagerOptions struct {
+	secretID  string
+	region    string
+	stage     string
+	versionID string
+	binary    string
+}
+
+func parseSecretsManagerOptions(q Query) (secretsManagerOptions, error) {
+
+	secretID, options, errOptions := namedOptions(q)
+	if errOptions != nil {
+		return secretsManagerOptions{}, errOptions
+	}
+
+	for name := range options {
+		switch name {
+		case "stage", "version", "binary":
+		default:
+			return secretsManagerOptions{}, fmt.Errorf("unknown option '%s', expecting stage|version|binary: %s",
+				name, q.Name)
+		}
+	}
+
+	opt := secretsManagerOptions{
+		secretID:  secretID,
+		region:    q.Region,
+		stage:     options.Get("stage"),
+		versionID: options.Get("version"),
+		binary:    options.Get("binary"),
+	}
+
+	if opt.secretID == "" {
+		return opt, fmt.Errorf("empty secret id: %s", q.Name)
+	}
+
+	if opt.stage == "" && opt.versionID == "" {
+		opt.stage = "AWSCURRENT"
+	}
+
+	switch opt.binary {
+	case "", "base64", "raw":
+	default:
+		return opt, fmt.Errorf("bad binary option '%s', expecting base64|raw", opt.binary)
+	}
+
+	if arn.IsARN(secretID) {
+		a, errARN := arn.Parse(secretID)
+		if errARN != nil {
+			return opt, errARN
+		}
+		opt.region = a.Region
+	}
+
+	return opt, nil
+}
+
+// secretValue returns the string secret, or the binary secret encoded as requested.
+func secretValue(me string, opt secretsManagerOptions, secretString *string, secretBinary []byte) (string, error) {
+	switch {
+	case secretString != nil:
+		return *secretString, nil
+	case secretBinary != nil && opt.binary == "raw":
+		return string(secretBinary), nil
+	case secretBinary != nil:
+		return base64.StdEncoding.EncodeToString(secretBinary), nil
+	}
+	return "", fmt.Errorf("%s: secret has neither string nor binary value: %s", me, opt.secretID)
+}
+
+type secretEntry struct {
+	secretString *string
+	secretBinary []byte
+	err          error
+}
+
+// querySecretBatch fetches secrets with BatchGetSecretValue, up to 20 secrets per call.
+// BatchGetSecretValue returns only the current version, thus queries selecting
+// a version, or a region from ARN, fall back to GetSecretValue.
+func querySecretBatch(ctx context.Context, queries []Query) []BatchResult {
+	const me = "querySecretBatch"
+
+	results := make([]BatchResult, len(queries))
+
+	var batch []Query
+	var batchIndex []int
+
+	for i, q := range queries {
+		opt, errOptions := parseSecretsManagerOptions(q)
+		switch {
+		case errOptions != nil:
+			results[i].Err = fmt.Errorf("%w: %s: %w", ErrMalformedReference, me, errOptions)
+		case opt.stage != "AWSCURRENT" || opt.versionID != "" || opt.region != q.Region:
+			results[i].Value, results[i].Err = querySecret(ctx, q)
+		default:
+			batch = append(batch, q)
+			batchIndex = append(batchIndex, i)
+		}
+	}
+
+	if len(batch) == 0 {
+		return results
+	}
+
+	awsConfig, errAwsConfig := batch[0].AwsConfig(ctx)
+
+	sm := secretsmanager.NewFromConfig(awsConfig, func(o *secretsmanager.Options) {
+		if endpoint := batch[0].EndpointURL(); endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	})
+
+	fetch := func(secretIDs []string) (map[string]secretEntry, error) {
+		if errAwsConfig != nil {
+			return nil, errAwsConfig
+		}
+
+		input := &secretsmanager.BatchGetSecretValueInput{
+			SecretIdList: secretIDs,
+		}
+
+		found := map[string]secretEntry{}
+		var values []types.SecretValueEntry
+
+		for {
+			result, errBatch := sm.BatchGetSecretValue(ctx, input)
+			if errBatch != nil {
+				return nil, errBatch
+			}
+
+			for _, v := range result.SecretValues {
+				e := secretEntry{secretString: v.SecretString, secretBinary: v.SecretBinary}
+				found[aws.ToString(v.Name)] = e
+				found[aws.ToString(v.ARN)] = e
+			}
+			values = append(values, result.SecretValues...)
+
+			for _, e := range result.Errors {
+				found[aws.ToString(e.SecretId)] = secretEntry{err: &smithy.GenericAPIError{
+					Code:    aws.ToString(e.ErrorCode),
+					Message: aws.ToString(e.Message),
+				}}
+			}
+
+			if result.NextToken == nil {
+				break
+			}
+			input.NextToken = result.NextToken
+		}
+
+		// ids resolved by secrets manager, like partial ARNs
+		for _, id := range secretIDs {
+			if _, isFound := found[id]; isFound {
+				continue
+			}
+			for _, v := range values {
+				if secretMatches(id, aws.ToString(v.ARN)) {
+					found[id] = secretEntry{secretString: v.SecretString, secretBinary: v.SecretBinary}
+					break
+				}
+			}
+		}
+
+		return found, nil
+	}
+
+	keyOf := func(q Query) (string, error) {
+		opt, errOptions := parseSecretsManagerOptions(q)
+		return opt.secretID, errOptions
+	}
+
+	valueOf := func(q Query, e secretEntry) (string, error) {
+		if e.err != nil {
+			return "", e.err
+		}
+		opt, _ := parseSecretsManagerOptions(q)
+		return secretValue(me, opt, e.secretString, e.secretBinary)
+	}
+
+	for i, r := range batchByKey(batch, 20, keyOf, fetch, valueOf) {
+		results[batchIndex[i]] = r
+	}
+
+	return results
+}
+
+// secretMatches reports whether the secret ARN answers the requested
+// secret id, when the id is neither the secret name nor its ARN: a
+// partial ARN, without the random suffix, or the name followed by
+// the suffix.
+func secretMatches(secretID, secretARN string) bool {
+	if strings.HasPrefix(secretARN, secretID+"-") {
+		return len(secretARN) == len(secretID)+len("-AbCdEf")
+	}
+	return strings.HasSuffix(secretARN, ":secret:"+secretID)
 }
@@ -0,0 +1,139 @@
+package secret
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+type secretsManagerOptionsTestCase struct {
+	name          string
+	reference     string
+	expectedError bool
+	secretID      string
+	region        string
+	stage         string
+	versionID     string
+	binary        string
+}
+
+const testSecretARN = "arn:aws:secretsmanager:us-west-2:123456789012:secret:db-AbCdEf"
+
+var secretsManagerOptionsTestTable = []secretsManagerOptionsTestCase{
+	{"name", "aws-secretsmanager:us-east-1:db:uri", expectOk, "db", "us-east-1", "AWSCURRENT", "", ""},
+	{"stage", "aws-secretsmanager:us-east-1:db,stage=AWSPREVIOUS:uri", expectOk, "db", "us-east-1", "AWSPREVIOUS", "", ""},
+	{"version", "aws-secretsmanager:us-east-1:db,version=v1:uri", expectOk, "db", "us-east-1", "", "v1", ""},
+	{"binary", "aws-secretsmanager:us-east-1:db,binary=raw", expectOk, "db", "us-east-1", "AWSCURRENT", "", "raw"},
+	{"bad binary", "aws-secretsmanager:us-east-1:db,binary=hex", expectError, "", "", "", "", ""},
+	{"unknown option", "aws-secretsmanager:us-east-1:db,stg=AWSPREVIOUS", expectError, "", "", "", "", ""},
+	{"bad option", "aws-secretsmanager:us-east-1:db,AWSPREVIOUS", expectError, "", "", "", "", ""},
+	{"arn", "aws-secretsmanager|us-east-1|" + testSecretARN + ",stage=AWSPENDING|uri", expectOk, testSecretARN, "us-west-2", "AWSPENDING", "", ""},
+	{"uri", "secret+aws-secretsmanager://us-east-1/db?stage=custom&field=uri", expectOk, "db", "us-east-1", "custom", "", ""},
+	{"uri arn", "secret+aws-secretsmanager:///" + testSecretARN + "?field=uri", expectOk, testSecretARN, "us-west-2", "AWSCURRENT", "", ""},
+	{"uri empty", "secret+aws-secretsmanager://us-east-1/", expectError, "", "", "", "", ""},
+}
+
+func TestParseSecretsManagerOptions(t *testing.T) {
+	for _, data := range secretsManagerOptionsTestTable {
+		t.Run(data.name, func(t *testing.T) {
+			ref, errRef := ParseReference(data.reference)
+			if errRef != nil {
+				t.Fatalf("parse reference: %v", errRef)
+			}
+			q := Query{Region: ref.Region, Name: ref.Location, Reference: ref}
+			opt, err := parseSecretsManagerOptions(q)
+			if data.expectedError {
+				if err == nil {
+					t.Errorf("expected error, got: %+v", opt)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			expected := secretsManagerOptions{
+				secretID:  data.secretID,
+				region:    data.region,
+				stage:     data.stage,
+				versionID: data.versionID,
+				binary:    data.binary,
+			}
+			if opt != expected {
+				t.Errorf("expected=%+v got=%+v", expected, opt)
+			}
+		})
+	}
+}
+
+func TestSecretValue(t *testing.T) {
+	str := "text"
+	bin := []byte{0xff, 'a'}
+
+	if v, _ := secretValue("test", secretsManagerOptions{}, &str, nil); v != str {
+		t.Errorf("string: expected=%s got=%s", str, v)
+	}
+	if v, _ := secretValue("test", secretsManagerOptions{}, nil, bin); v != "/2E=" {
+		t.Errorf("base64: expected=/2E= got=%s", v)
+	}
+	if v, _ := secretValue("test", secretsManagerOptions{binary: "raw"}, nil, bin); v != string(bin) {
+		t.Errorf("raw: expected=%q got=%q", bin, v)
+	}
+	if _, err := secretValue("test", secretsManagerOptions{}, nil, nil); err == nil {
+		t.Errorf("expected error for missing value")
+	}
+}
+
+func TestSecretBatchPartialID(t *testing.T) {
+
+	const (
+		name       = "db"
+		partialARN = "arn:aws:secretsmanager:us-west-2:123456789012:secret:db"
+		fullARN    = partialARN + "-AbCdEf"
+	)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var input struct{ SecretIdList []string }
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &input); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// secrets manager resolves ids, but answers with name and full ARN
+		values := []map[string]string{}
+		for _, id := range input.SecretIdList {
+			if id == name || strings.HasPrefix(fullARN, id) || strings.HasSuffix(fullARN, ":secret:"+id) {
+				values = append(values, map[string]string{"Name": name, "ARN": fullARN, "SecretString": `{"uri":"mongodb://db"}`})
+			}
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		json.NewEncoder(w).Encode(map[string]any{"SecretValues": values, "Errors": []any{}})
+	}))
+	defer ts.Close()
+
+	creds := aws.Credentials{AccessKeyID: "AKID", SecretAccessKey: "SECRET"}
+
+	secret := New(Options{AwsConfigSource: &staticAwsConfig{credentials: creds, endpoint: ts.URL}})
+
+	names := []string{
+		"aws-secretsmanager|us-west-2|" + partialARN + "|uri",
+		"aws-secretsmanager:us-west-2:db-AbCdEf:uri",
+		"aws-secretsmanager:us-west-2:db:uri",
+	}
+
+	values, err := secret.RetrieveMany(context.TODO(), names)
+	if err != nil {
+		t.Fatalf("retrieve many: %v", err)
+	}
+
+	for _, n := range names {
+		if values[n] != "mongodb://db" {
+			t.Errorf("%s: expected=mongodb://db got=%s", n, values[n])
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package secret
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+)
+
+/*
+AWS SigV4 request signing for the #http backend, for Lambda function URLs
+and API Gateway endpoints with IAM auth:
+
+	auth=sigv4       sign requests with credentials from Options.AwsConfigSource
+	service=lambda   service name, inferred from lambda-url and execute-api hosts
+
+The signing region is the reference region, also inferred from lambda-url and
+execute-api hosts:
+
+export DB_URI=#http:us-east-1:GET,https,tttt.lambda-url.us-east-1.on.aws,443,/,,,,auth=sigv4:uri
+
+export DB_URI=secret+http://tttt.lambda-url.us-east-1.on.aws/?auth=sigv4&field=uri
+*/
+
+type sigv4Options struct {
+	service string
+	region  string
+}
+
+// sigv4OptionNames lists options for SigV4 signing.
+var sigv4OptionNames = []string{"service"}
+
+func parseSigV4Options(q Query, u string, options url.Values) (*sigv4Options, error) {
+	if options.Get("auth") != "sigv4" {
+		if options.Has("service") {
+			return nil, fmt.Errorf("option 'service' requires auth=sigv4")
+		}
+		return nil, nil
+	}
+
+	parsed, errURL := url.Parse(u)
+	if errURL != nil {
+		return nil, errURL
+	}
+
+	hostService, hostRegion := sigv4FromHost(parsed.Hostname())
+
+	opt := &sigv4Options{
+		service: options.Get("service"),
+		region:  q.Region,
+	}
+
+	if q.Reference.URI && q.Reference.Region == q.Reference.Host {
+		opt.region = "" // region defaulted to URI authority
+	}
+
+	if opt.service == "" {
+		opt.service = hostService
+	}
+	if opt.region == "" {
+		opt.region = hostRegion
+	}
+
+	if opt.service == "" {
+		return nil, fmt.Errorf("auth=sigv4 requires service option for host '%s'", parsed.Hostname())
+	}
+	if opt.region == "" {
+		return nil, fmt.Errorf("auth=sigv4 requires region for host '%s'", parsed.Hostname())
+	}
+
+	return opt, nil
+}
+
+// sigv4FromHost infers service and region from AWS hostnames:
+// id.lambda-url.us-east-1.on.aws and id.execute-api.us-east-1.amazonaws.com
+func sigv4FromHost(host string) (string, string) {
+	labels := strings.Split(host, ".")
+	if len(labels) < 3 {
+		return "", ""
+	}
+	switch labels[1] {
+	case "lambda-url":
+		return "lambda", labels[2]
+	case "execute-api":
+		return "execute-api", labels[2]
+	}
+	return "", ""
+}
+
+// sign adds SigV4 authentication headers to the request.
+func (o *sigv4Options) sign(ctx context.Context, q Query, req *http.Request, body []byte) error {
+	const me = "sigv4Options.sign"
+
+	awsConfig, errAwsConfig := q.awsConfig.get(ctx, o.region)
+	if errAwsConfig != nil {
+		return fmt.Errorf("%s: aws config: %w", me, errAwsConfig)
+	}
+
+	if awsConfig.Credentials == nil {
+		return fmt.Errorf("%w: %s: missing aws credentials", ErrAccessDenied, me)
+	}
+
+	creds, errCreds := awsConfig.Credentials.Retrieve(ctx)
+	if errCreds != nil {
+		return fmt.Errorf("%w: %s: retrieve aws credentials: %w", ErrAccessDenied, me, errCreds)
+	}
+
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	return v4.NewSigner().SignHTTP(ctx, creds, req, payloadHash, o.service, o.region, time.Now())
+}
@@ -0,0 +1,131 @@
+package secret
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+)
+
+// staticAwsConfig provides fixed credentials without contacting aws.
+type staticAwsConfig struct {
+	credentials aws.Credentials
+	endpoint    string // optional fake aws endpoint
+}
+
+func (s *staticAwsConfig) get(_ context.Context, region string) (aws.Config, error) {
+	return aws.Config{
+		Region:      region,
+		Credentials: credentials.StaticCredentialsProvider{Value: s.credentials},
+	}, nil
+}
+
+func (s *staticAwsConfig) endpointURL() string { return s.endpoint }
+
+type sigv4OptionsTestCase struct {
+	name          string
+	reference     string
+	expectedError bool
+	service       string
+	region        string
+}
+
+var sigv4OptionsTestTable = []sigv4OptionsTestCase{
+	{"lambda url", "secret+http://tttt.lambda-url.us-east-1.on.aws/?auth=sigv4", expectOk, "lambda", "us-east-1"},
+	{"execute api", "secret+http://api1.execute-api.sa-east-1.amazonaws.com/prod/db?auth=sigv4", expectOk, "execute-api", "sa-east-1"},
+	{"uri region option", "secret+http://secrets.internal/db?auth=sigv4&service=execute-api&region=eu-west-1", expectOk, "execute-api", "eu-west-1"},
+	{"legacy region", "#http:us-east-2:GET,https,secrets.internal,443,/,,,,auth=sigv4,service=lambda", expectOk, "lambda", "us-east-2"},
+	{"legacy inferred", "#http::GET,https,tttt.lambda-url.us-east-1.on.aws,443,/,,,,auth=sigv4", expectOk, "lambda", "us-east-1"},
+	{"missing service", "secret+http://secrets.internal/db?auth=sigv4&region=eu-west-1", expectError, "", ""},
+	{"missing region", "secret+http://secrets.internal/db?auth=sigv4&service=lambda", expectError, "", ""},
+	{"service without auth", "secret+http://secrets.internal/db?service=lambda", expectError, "", ""},
+	{"sigv4 with token", "secret+http://tttt.lambda-url.us-east-1.on.aws/?auth=sigv4&token=Bearer+x", expectError, "", ""},
+}
+
+func TestParseSigV4Options(t *testing.T) {
+	for _, data := range sigv4OptionsTestTable {
+		t.Run(data.name, func(t *testing.T) {
+			ref, errRef := ParseReference(data.reference)
+			if errRef != nil {
+				t.Fatalf("parse reference: %v", errRef)
+			}
+			opt, err := parseHTTPOptions(Query{Region: ref.Region, Name: ref.Location, Reference: ref})
+			if data.expectedError {
+				if err == nil {
+					t.Errorf("expected error, got: %+v", opt)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if opt.sigv4 == nil {
+				t.Fatalf("expected sigv4 options")
+			}
+			if opt.sigv4.service != data.service {
+				t.Errorf("service: expected=%s got=%s", data.service, opt.sigv4.service)
+			}
+			if opt.sigv4.region != data.region {
+				t.Errorf("region: expected=%s got=%s", data.region, opt.sigv4.region)
+			}
+		})
+	}
+}
+
+func TestHTTPSigV4(t *testing.T) {
+
+	creds := aws.Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret"}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// sign again with the same time and compare signatures
+		received := r.Header.Get("Authorization")
+		signTime, errTime := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+		if errTime != nil {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		check, _ := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), nil)
+		check.ContentLength = r.ContentLength
+		for _, h := range []string{"Content-Type", "X-Amz-Content-Sha256"} {
+			check.Header.Set(h, r.Header.Get(h))
+		}
+		err := v4.NewSigner().SignHTTP(context.Background(), creds, check,
+			r.Header.Get("X-Amz-Content-Sha256"), "lambda", "us-east-1", signTime)
+		if err != nil || check.Header.Get("Authorization") != received || len(body) == 0 {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, `{"uri":"mongodb://sigv4"}`)
+	}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+
+	secret := New(Options{AwsConfigSource: &staticAwsConfig{credentials: creds}, CacheTTLSeconds: -1})
+
+	name := fmt.Sprintf("secret+http://%s/?proto=http&method=POST&content-type=application/json&body=%s&auth=sigv4&service=lambda&region=us-east-1&retries=0&field=uri",
+		u.Host, url.QueryEscape(`{"parameter":"mongodb"}`))
+
+	value, err := secret.RetrieveWithError(name)
+	if err != nil {
+		t.Fatalf("retrieve: %v", err)
+	}
+	if value != "mongodb://sigv4" {
+		t.Errorf("expected=mongodb://sigv4 got=%s", value)
+	}
+
+	// wrong credentials
+	wrong := New(Options{AwsConfigSource: &staticAwsConfig{credentials: aws.Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wrong"}}, CacheTTLSeconds: -1})
+	if _, err := wrong.RetrieveWithError(name); err == nil {
+		t.Errorf("expected error for wrong credentials")
+	}
+}
@@ -0,0 +1,116 @@
+package secret
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+//
+// When the store fails, we can still serve the last known good value
+// from the cache, up to StaleIfErrorSeconds past the cache TTL:
+//
+//     CacheTTLSeconds=60 StaleIfErrorSeconds=3600
+//
+// Repeated failures are remembered, so that a store that is down is
+// not hammered by every Retrieve call. After a failure, queries are
+// skipped for ErrorBackoffSeconds, doubling on each consecutive failure
+// up to ErrorBackoffMaxSeconds.
+//
+
+type failure struct {
+	err     error
+	count   int
+	retryAt time.Time // queries are skipped until this instant
+}
+
+func (s *Secret) staleWindow() time.Duration {
+	return time.Second * time.Duration(s.options.StaleIfErrorSeconds)
+}
+
+// staleIfError returns the stale cached value, if allowed, otherwise the error.
+func (s *Secret) staleIfError(cacheKey string, err error) (string, error) {
+	const me = "Secret.staleIfError"
+
+	if s.options.StaleIfErrorSeconds < 1 {
+		return "", err
+	}
+
+	cached, found := s.cache.Get(cacheKey)
+	if !found {
+		return "", err
+	}
+
+	elapsed := time.Since(cached.Created)
+	if elapsed >= cached.TTL+s.staleWindow() {
+		return "", err
+	}
+
+	s.options.Printf("%s: WARNING: serving stale value: %s (elapsed=%s TTL=%s stale_if_error=%s): %v",
+		me, cacheKey, elapsed, cached.TTL, s.staleWindow(), err)
+
+	return cached.Value, nil
+}
+
+// backoff returns an error if queries for the secret are currently
+// being skipped due to previous failures.
+func (s *Secret) backoff(cacheKey string) error {
+	if s.options.ErrorBackoffSeconds < 1 {
+		return nil
+	}
+
+	s.failuresLock.Lock()
+	f, found := s.failures[cacheKey]
+	s.failuresLock.Unlock()
+
+	if !found {
+		return nil
+	}
+
+	if wait := time.Until(f.retryAt); wait > 0 {
+		return fmt.Errorf("backing off %s after %d failures: %w",
+			wait.Round(time.Millisecond), f.count, f.err)
+	}
+
+	return nil
+}
+
+// callerGaveUp reports whether err was caused by the caller giving up,
+// through cancellation or its own deadline on ctx, the context the query
+// ran under. Such errors say nothing about the store.
+func callerGaveUp(ctx context.Context, err error) bool {
+	return ctx.Err() != nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded))
+}
+
+// recordFailure starts or extends backoff for the secret.
+// Callers skip errors for which callerGaveUp holds.
+func (s *Secret) recordFailure(cacheKey string, err error) {
+	if s.options.ErrorBackoffSeconds < 1 {
+		return
+	}
+
+	s.failuresLock.Lock()
+	defer s.failuresLock.Unlock()
+
+	f := s.failures[cacheKey]
+	f.err = err
+	f.count++
+
+	backoff := time.Second * time.Duration(s.options.ErrorBackoffSeconds)
+	limit := time.Second * time.Duration(s.options.ErrorBackoffMaxSeconds)
+	for i := 1; i < f.count && backoff < limit; i++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, limit)
+
+	f.retryAt = time.Now().Add(backoff)
+
+	s.failures[cacheKey] = f
+}
+
+func (s *Secret) clearFailure(cacheKey string) {
+	s.failuresLock.Lock()
+	delete(s.failures, cacheKey)
+	s.failuresLock.Unlock()
+}
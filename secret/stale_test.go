@@ -0,0 +1,182 @@
+package secret
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestStaleIfError(t *testing.T) {
+
+	var calls int
+	var fail bool
+
+	secret := New(Options{
+		AwsConfigSource:     &AwsConfigSource{},
+		StaleIfErrorSeconds: 3600,
+		ErrorBackoffSeconds: 60,
+	})
+
+	secret.RegisterBackend("flaky-store", BackendFunc(func(_ context.Context, _ Query) (string, error) {
+		calls++
+		if fail {
+			return "", errors.New("store is down")
+		}
+		return `{"uri":"mongodb://good"}`, nil
+	}))
+
+	const name = "flaky-store:us-east-1:db:uri"
+	const expected = "mongodb://good"
+
+	if value, err := secret.RetrieveWithError(name); err != nil || value != expected {
+		t.Fatalf("initial retrieve: expected=%s got=%s: %v", expected, value, err)
+	}
+
+	// expire cache entry
+	const cacheKey = "flaky-store:us-east-1:db"
+	age(t, secret.cache, cacheKey, 2*time.Minute)
+
+	fail = true
+
+	value, err := secret.RetrieveWithError(name)
+	if err != nil {
+		t.Fatalf("stale retrieve: unexpected error: %v", err)
+	}
+	if value != expected {
+		t.Errorf("stale retrieve: expected=%s got=%s", expected, value)
+	}
+	if calls != 2 {
+		t.Errorf("stale retrieve: expected 2 calls, got %d", calls)
+	}
+
+	// store is backing off: must not be queried
+	value, err = secret.RetrieveWithError(name)
+	if err != nil || value != expected {
+		t.Errorf("backoff retrieve: expected=%s got=%s: %v", expected, value, err)
+	}
+	if calls != 2 {
+		t.Errorf("backoff retrieve: expected 2 calls, got %d", calls)
+	}
+
+	// beyond stale window: error
+	age(t, secret.cache, cacheKey, 2*time.Hour)
+
+	if _, err := secret.RetrieveWithError(name); err == nil {
+		t.Errorf("expected error beyond stale window")
+	}
+}
+
+// age moves cache entry creation into the past.
+func age(t *testing.T, c Cache, key string, d time.Duration) {
+	entry, found := c.Get(key)
+	if !found {
+		t.Fatalf("cache entry not found: %s", key)
+	}
+	entry.Created = entry.Created.Add(-d)
+	c.Put(key, entry)
+}
+
+func TestErrorBackoff(t *testing.T) {
+
+	secret := New(Options{
+		AwsConfigSource:        &AwsConfigSource{},
+		ErrorBackoffSeconds:    10,
+		ErrorBackoffMaxSeconds: 30,
+	})
+
+	errStore := errors.New("store is down")
+
+	const key = "flaky-store:us-east-1:db"
+
+	for _, expected := range []time.Duration{10 * time.Second, 20 * time.Second, 30 * time.Second, 30 * time.Second} {
+		secret.recordFailure(key, errStore)
+		wait := time.Until(secret.failures[key].retryAt)
+		if wait > expected || wait < expected-time.Second {
+			t.Errorf("backoff: expected=%v got=%v", expected, wait)
+		}
+		if err := secret.backoff(key); !errors.Is(err, errStore) {
+			t.Errorf("backoff error: expected wrapped store error, got: %v", err)
+		}
+	}
+
+	secret.clearFailure(key)
+
+	if err := secret.backoff(key); err != nil {
+		t.Errorf("unexpected backoff after clear: %v", err)
+	}
+}
+
+func TestErrorBackoffIgnoresCallerCancel(t *testing.T) {
+
+	secret := New(Options{
+		AwsConfigSource:     &AwsConfigSource{},
+		ErrorBackoffSeconds: 60,
+		CacheTTLSeconds:     -1,
+	})
+
+	var batchTimeout bool // batch fails on its own timeout, caller still waiting
+
+	secret.RegisterBackend("slow-store", batchBackend{
+		func(_ context.Context, q Query) (string, error) {
+			if q.Name == "timeout" {
+				return "", fmt.Errorf("store timeout: %w", context.DeadlineExceeded)
+			}
+			return "value", nil
+		},
+		func(ctx context.Context, queries []Query) []BatchResult {
+			err := fmt.Errorf("store timeout: %w", context.DeadlineExceeded)
+			if !batchTimeout {
+				<-ctx.Done()
+				err = ctx.Err()
+			}
+			results := make([]BatchResult, len(queries))
+			for i := range results {
+				results[i].Err = err
+			}
+			return results
+		},
+	})
+
+	const name = "slow-store:us-east-1:db"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	if _, err := secret.RetrieveMany(ctx, []string{name}); err == nil {
+		t.Fatalf("expected error from cancelled batch")
+	}
+
+	if err := secret.backoff(name); err != nil {
+		t.Errorf("caller deadline must not start backoff: %v", err)
+	}
+
+	value, err := secret.RetrieveWithError(name)
+	if err != nil {
+		t.Fatalf("retrieve after cancelled call: %v", err)
+	}
+	if value != "value" {
+		t.Errorf("expected=value got=%s", value)
+	}
+
+	// backend timeouts start backoff, for batch and single queries
+
+	batchTimeout = true
+
+	if _, err := secret.RetrieveMany(context.Background(), []string{name}); err == nil {
+		t.Fatalf("expected error from batch timeout")
+	}
+	if err := secret.backoff(name); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("batch timeout: expected backoff, got: %v", err)
+	}
+
+	const timeout = "slow-store:us-east-1:timeout"
+
+	if _, err := secret.RetrieveWithError(timeout); err == nil {
+		t.Fatalf("expected error from query timeout")
+	}
+	if err := secret.backoff(timeout); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("query timeout: expected backoff, got: %v", err)
+	}
+}
@@ -3,118 +3,268 @@ package secret
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
 	"strings"
 
 	vault "github.com/hashicorp/vault/api"
 	auth "github.com/hashicorp/vault/api/auth/aws"
-	"github.com/udhos/boilerplate/boilerplate"
 )
 
 /*
 export DB_URI=vault::token,dev-only-token,http,localhost,8200,secret/foo/key:field
+
+export DB_URI='secret+vault://localhost:8200/secret/foo/key?auth=token&token=dev-only-token&proto=http&field=field'
+# Options: auth (default aws-role), token, role, role-id, username, proto (default https)
+
+Auth methods, with the second legacy field as auth option:
+
+	token       auth option is the token, empty for VAULT_TOKEN from environment
+	aws-role    auth option is the role
+	approle     auth option is the role id, requires secret-id option
+	kubernetes  auth option is the role, jwt option defaults to @/var/run/secrets/kubernetes.io/serviceaccount/token
+	jwt, oidc   auth option is the role (optional), requires jwt option
+	userpass    auth option is the username, requires password option
+	ldap        auth option is the username, requires password option
+	cert        auth option is the certificate role (optional), requires client-cert and client-key options
+
+Further options, appended to legacy syntax as ,name=value or given as URI query parameters:
+
+	mount=path        auth mount path, defaults to the auth method name (aws for aws-role)
+	secret-id=value   approle secret id
+	jwt=value         jwt for kubernetes, jwt and oidc
+	password=value    password for userpass and ldap
+	client-cert=path  PEM client certificate for cert auth
+	client-key=path   PEM client key for cert auth
+	ca=path           PEM CA certificate to verify vault server
+	namespace=ns      vault enterprise or openbao namespace
+	engine=auto       secrets engine: auto (default), kv1, kv2 or logical
+	version=3         kv2 secret version, defaults to latest
+
+With engine=auto, the engine is detected from sys/internal/ui/mounts, falling
+back to kv2 where detection is not available. For kv engines, the last path
+segment is the key within the secret. Other engines are read as logical paths,
+returning the whole data as JSON:
+
+export DB_USER=vault::token,dev-only-token,https,vault,8200,database/creds/readonly,engine=logical:username
+
+Credentials, including token and role id, are read from a file as @/path/to/file,
+or resolved as secret references, or else taken as literal values:
+
+export DB_URI=vault::approle,@/run/secrets/role-id,https,vault,8200,secret/myapp1/mongodb,secret-id=@/run/secrets/secret-id:uri
+export DB_URI=vault::kubernetes,myapp,https,vault,8200,secret/myapp1/mongodb:uri
 */
-func queryVault(debug bool, printf boilerplate.FuncPrintf, _ /*unused*/ AwsConfigSolver, vaultOptions string) (string, error) {
+func queryVault(ctx context.Context, q Query) (string, error) {
 	const me = "queryVault"
 
 	//
 	// parse fields
 	//
 
-	const fields = 6
-
-	options := strings.SplitN(vaultOptions, ",", fields)
-	if len(options) < fields {
-		return "", fmt.Errorf("%s: bad vault options, expecting %d fields - got: '%s'",
-			me, fields, vaultOptions)
-	}
-
-	// drop spaces
-	for i, s := range options {
-		options[i] = strings.TrimSpace(s)
+	opt, errParse := parseVaultOptions(q, vaultReadOptionNames)
+	if errParse != nil {
+		return "", fmt.Errorf("%w: %s: %w", ErrMalformedReference, me, errParse)
 	}
 
-	authType := options[0]
-	authOption := options[1]
-	proto := options[2]
-	host := options[3]
-	port := options[4]
-	path := options[5]
+	return vaultQuery(ctx, q, opt, func(client *vault.Client, u string) (string, error) {
+		return vaultRead(ctx, q, client, opt, u)
+	})
+}
 
-	if port != "" {
-		host += ":" + port
-	}
+// vaultQuery runs the request with an authenticated client,
+// logging in again once if vault rejects the cached token.
+func vaultQuery(ctx context.Context, q Query, opt vaultOptions, request func(client *vault.Client, u string) (string, error)) (string, error) {
+	const me = "vaultQuery"
 
 	//
 	// build vault url
 	//
 
-	u := proto + "://" + host
+	u := opt.proto + "://" + opt.host
 
-	if debug {
-		printf("DEBUG %s: vault server URL: %s", me, u)
+	if q.Debug {
+		q.Printf("DEBUG %s: vault server URL: %s", me, u)
 	}
 
 	//
-	// resolve path: secret/<secretPath>/<key>
+	// login
 	//
 
-	mountPath, secretPath, key, errPath := parseSecretPath(path)
-	if errPath != nil {
-		return "", errPath
-	}
-
-	if debug {
-		printf("DEBUG %s: raw_path=%s mount_path=%s secret_path=%s key=%s",
-			me, path, mountPath, secretPath, key)
+	client, errLogin := q.vaultClient(ctx, opt, u, nil)
+	if errLogin != nil {
+		return "", errLogin
 	}
 
 	//
-	// login
+	// query vault api
 	//
 
-	var client *vault.Client
-
-	switch authType {
-	case "token":
-		var err error
-		client, err = vaultClientFromToken(u, authOption)
-		if err != nil {
-			return "", err
+	str, err := request(client, u)
+	if isVaultForbidden(err) && q.secret != nil {
+		// token revoked or expired: login again
+		if q.Debug {
+			q.Printf("DEBUG %s: forbidden, checking token: %v", me, err)
 		}
-	case "aws-role", "":
-		var err error
-		client, err = vaultClientFromAwsRole(u, authOption)
-		if err != nil {
-			return "", err
+		relogged, errRelogin := q.vaultClient(ctx, opt, u, client)
+		if errRelogin != nil {
+			return "", errRelogin
 		}
-	default:
-		return "", fmt.Errorf("unexpected auth type (token|aws-role): '%s': %s", authType, vaultOptions)
+		if relogged == client {
+			return str, err // token is valid, path denied by policy
+		}
+		str, err = request(relogged, u)
+	}
+
+	return str, err
+}
+
+// vaultRead reads the secret according to the engine option.
+func vaultRead(ctx context.Context, q Query, client *vault.Client, opt vaultOptions, u string) (string, error) {
+	const me = "vaultRead"
+
+	path := strings.Trim(strings.TrimSpace(opt.path), "/")
+
+	engine := opt.engine()
+
+	var mount vaultMount
+
+	if engine == "auto" {
+		var errDetect error
+		mount, errDetect = q.vaultMount(ctx, client, opt, u, path)
+		var respErr *vault.ResponseError
+		switch {
+		case errors.As(errDetect, &respErr) && respErr.StatusCode != http.StatusForbidden:
+			// transient failure, like 5xx or 429: detect again on next query
+			return "", fmt.Errorf("%s: mount detection: %w", me, errDetect)
+		case errDetect != nil:
+			// no permission for mount detection, or old server
+			if q.Debug {
+				q.Printf("DEBUG %s: mount detection failed, assuming kv2: %v", me, errDetect)
+			}
+			engine = "kv2"
+		case mount.kind == "kv" && mount.version == "2":
+			engine = "kv2"
+		case mount.kind == "kv" || mount.kind == "generic":
+			engine = "kv1"
+		default:
+			engine = "logical"
+		}
+	}
+
+	if opt.version > 0 && engine != "kv2" {
+		return "", fmt.Errorf("%w: %s: version option requires kv2 engine, got %s: %s",
+			ErrMalformedReference, me, engine, path)
 	}
 
-	client.SetAddress(u)
+	if engine == "logical" {
+		return vaultReadLogical(ctx, q, client, path)
+	}
 
 	//
-	// query vault api
+	// resolve path: secret/<secretPath>/<key>
 	//
 
-	s, err := client.KVv2(mountPath).Get(context.Background(), secretPath)
+	mountPath, secretPath, key, errPath := parseSecretPath(path)
+	if errPath != nil {
+		return "", fmt.Errorf("%w: %s: %w", ErrMalformedReference, me, errPath)
+	}
+
+	// detected mount may span multiple segments: kv/team/app/key
+	if m := strings.Trim(mount.path, "/"); m != "" && m != mountPath && strings.HasPrefix(path, m+"/") {
+		mountPath = m
+		secretPath = strings.TrimPrefix(path, m+"/")
+		secretPath = secretPath[:max(strings.LastIndexByte(secretPath, '/'), 0)]
+		if secretPath == "" {
+			return "", fmt.Errorf("%w: %s: empty secret path under mount '%s': %s",
+				ErrMalformedReference, me, mountPath, path)
+		}
+	}
+
+	if q.Debug {
+		q.Printf("DEBUG %s: raw_path=%s engine=%s mount_path=%s secret_path=%s key=%s version=%d",
+			me, path, engine, mountPath, secretPath, key, opt.version)
+	}
+
+	var s *vault.KVSecret
+	var err error
+
+	switch {
+	case engine == "kv1":
+		s, err = client.KVv1(mountPath).Get(ctx, secretPath)
+	case opt.version > 0:
+		s, err = client.KVv2(mountPath).GetVersion(ctx, secretPath, opt.version)
+	default:
+		s, err = client.KVv2(mountPath).Get(ctx, secretPath)
+	}
+	if errors.Is(err, vault.ErrSecretNotFound) {
+		return "", fmt.Errorf("%w: %s: %w", ErrNotFound, me, err)
+	}
 	if err != nil {
 		return "", err
 	}
 
-	value := s.Data[key]
+	value, found := s.Data[key]
 
-	if debug {
-		printf("DEBUG %s: raw_path=%s mount_path=%s secret_path=%s key=%s raw_value=%v keyed_value=%v",
+	if q.Debug {
+		q.Printf("DEBUG %s: raw_path=%s mount_path=%s secret_path=%s key=%s raw_value=%v keyed_value=%v",
 			me, path, mountPath, secretPath, key, s.Data, value)
 	}
 
+	if !found {
+		return "", fmt.Errorf("%w: %s: key '%s' not found in secret: %s/%s",
+			ErrFieldMissing, me, key, mountPath, secretPath)
+	}
+
+	return vaultValue(q, value)
+}
+
+// vaultReadLogical reads an arbitrary path, like database/creds/role,
+// returning its data as JSON. Dynamic secrets are kept, and their leases
+// renewed, by the Secret.
+func vaultReadLogical(ctx context.Context, q Query, client *vault.Client, path string) (string, error) {
+	if q.secret == nil {
+		_, value, err := vaultReadSecret(ctx, q, client, path)
+		return value, err
+	}
+	return q.secret.vaultLease(q.Reference).get(ctx, q, client, path)
+}
+
+// vaultReadSecret reads an arbitrary path, returning the vault secret
+// and its data as JSON.
+func vaultReadSecret(ctx context.Context, q Query, client *vault.Client, path string) (*vault.Secret, string, error) {
+	const me = "vaultReadSecret"
+
+	s, err := client.Logical().ReadWithContext(ctx, path)
+	if err != nil {
+		return nil, "", err
+	}
+	if s == nil || s.Data == nil {
+		return nil, "", fmt.Errorf("%w: %s: no data at path: %s", ErrNotFound, me, path)
+	}
+
+	if q.Debug {
+		q.Printf("DEBUG %s: path=%s lease_id=%s lease_duration=%d renewable=%t",
+			me, path, s.LeaseID, s.LeaseDuration, s.Renewable)
+	}
+
+	value, errValue := vaultValue(q, s.Data)
+	return s, value, errValue
+}
+
+// vaultValue returns strings as is, and marshals other values to JSON.
+func vaultValue(q Query, value any) (string, error) {
+	const me = "vaultValue"
+
 	str, isStr := value.(string)
 
 	if !isStr {
-		if debug {
-			printf("DEBUG %s: value is not a string: %T: %v", me, value, value)
+		if q.Debug {
+			q.Printf("DEBUG %s: value is not a string: %T: %v", me, value, value)
 		}
 
 		// marshal non-string values to JSON so that we can return them as strings
@@ -129,50 +279,320 @@ func queryVault(debug bool, printf boilerplate.FuncPrintf, _ /*unused*/ AwsConfi
 	return str, nil
 }
 
-func vaultClientFromToken(u, token string) (*vault.Client, error) {
-	client, err := vaultClient(u)
-	if err != nil {
-		return nil, err
+type vaultOptions struct {
+	auth       string     // auth method
+	authOption string     // token, role, role id or username, depending on auth method
+	proto      string     // http|https
+	host       string     // host[:port]
+	path       string     // secret path
+	options    url.Values // named options
+	version    int        // kv2 version, 0 for latest
+}
+
+// vaultAuthMethods lists supported auth methods.
+var vaultAuthMethods = []string{"token", "aws-role", "approle", "kubernetes",
+	"jwt", "oidc", "userpass", "ldap", "cert"}
+
+// vaultOptionNames lists named options for login, that identify vault sessions.
+var vaultOptionNames = []string{"mount", "secret-id", "jwt", "password",
+	"client-cert", "client-key", "ca", "namespace"}
+
+// vaultReadOptionNames lists named options for reading secrets.
+var vaultReadOptionNames = []string{"engine", "version"}
+
+// vaultEngines lists values for the engine option.
+var vaultEngines = []string{"auto", "kv1", "kv2", "logical"}
+
+// defaultKubernetesJWT is the service account token mounted into pods.
+const defaultKubernetesJWT = "@/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+// parseVaultOptions parses address, auth and named options.
+// readOptionNames lists the backend options besides login options.
+func parseVaultOptions(q Query, readOptionNames []string) (vaultOptions, error) {
+
+	var opt vaultOptions
+
+	if ref := q.Reference; ref.URI {
+		for name := range ref.Options {
+			switch name {
+			case "auth", "token", "role", "role-id", "username", "proto":
+			default:
+				if !slices.Contains(vaultOptionNames, name) && !slices.Contains(readOptionNames, name) {
+					return opt, fmt.Errorf("unknown option '%s'", name)
+				}
+			}
+		}
+
+		opt.auth = ref.option("auth", "aws-role")
+		switch opt.auth {
+		case "token":
+			opt.authOption = ref.Options.Get("token")
+		case "approle":
+			opt.authOption = ref.Options.Get("role-id")
+		case "userpass", "ldap":
+			opt.authOption = ref.Options.Get("username")
+		default:
+			opt.authOption = ref.Options.Get("role")
+		}
+		opt.proto = ref.option("proto", "https")
+		opt.host = ref.Host
+		opt.path = ref.Location
+		opt.options = ref.Options
+	} else {
+		vaultOptions, options := trailingOptions(q.Name, slices.Concat(vaultOptionNames, readOptionNames))
+
+		const fields = 6
+
+		list := strings.SplitN(vaultOptions, ",", fields)
+		if len(list) < fields {
+			return opt, fmt.Errorf("bad vault options, expecting %d fields - got: '%s'",
+				fields, vaultOptions)
+		}
+
+		// drop spaces
+		for i, s := range list {
+			list[i] = strings.TrimSpace(s)
+		}
+
+		opt.auth = list[0]
+		opt.authOption = list[1]
+		opt.proto = list[2]
+		opt.host = list[3]
+		port := list[4]
+		opt.path = list[5]
+		opt.options = options
+
+		if port != "" {
+			opt.host += ":" + port
+		}
+
+		if opt.auth == "" {
+			opt.auth = "aws-role"
+		}
 	}
-	client.SetToken(token)
-	return client, nil
+
+	if !slices.Contains(vaultEngines, opt.engine()) {
+		return opt, fmt.Errorf("unexpected engine %v: '%s'", vaultEngines, opt.engine())
+	}
+
+	if v := opt.options.Get("version"); v != "" {
+		version, errVersion := strconv.Atoi(v)
+		if errVersion != nil || version < 1 {
+			return opt, fmt.Errorf("bad version option: '%s'", v)
+		}
+		opt.version = version
+	}
+
+	if !slices.Contains(vaultAuthMethods, opt.auth) {
+		return opt, fmt.Errorf("unexpected auth type %v: '%s'", vaultAuthMethods, opt.auth)
+	}
+
+	required := map[string][]string{
+		"approle":  {"secret-id"},
+		"jwt":      {"jwt"},
+		"oidc":     {"jwt"},
+		"userpass": {"password"},
+		"ldap":     {"password"},
+		"cert":     {"client-cert", "client-key"},
+	}
+	for _, name := range required[opt.auth] {
+		if opt.options.Get(name) == "" {
+			return opt, fmt.Errorf("auth=%s requires option '%s'", opt.auth, name)
+		}
+	}
+
+	switch opt.auth {
+	case "approle", "userpass", "ldap":
+		if opt.authOption == "" {
+			return opt, fmt.Errorf("auth=%s requires auth option", opt.auth)
+		}
+	}
+
+	return opt, nil
 }
 
-func vaultClientFromAwsRole(u, role string) (*vault.Client, error) {
-	client, err := vaultClient(u)
-	if err != nil {
-		return nil, err
+// engine returns the secrets engine option.
+func (o vaultOptions) engine() string {
+	if e := o.options.Get("engine"); e != "" {
+		return e
+	}
+	return "auto"
+}
+
+// mount returns the auth mount path.
+func (o vaultOptions) mount() string {
+	if m := strings.Trim(o.options.Get("mount"), "/"); m != "" {
+		return m
+	}
+	if o.auth == "aws-role" {
+		return "aws"
+	}
+	return o.auth
+}
+
+// vaultCredential resolves a credential from @file, secret reference or literal value.
+func vaultCredential(ctx context.Context, q Query, value string) (string, error) {
+	if file, isFile := strings.CutPrefix(value, "@"); isFile {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+	return q.Retrieve(ctx, value)
+}
+
+// vaultLogin creates a client authenticated with the auth method.
+// The login secret is nil for token auth.
+func vaultLogin(ctx context.Context, q Query, opt vaultOptions, u string) (*vault.Client, *vault.Secret, error) {
+	const me = "vaultLogin"
+
+	client, errClient := vaultClient(u, opt)
+	if errClient != nil {
+		return nil, nil, errClient
+	}
+
+	credential := func(name, value string) (string, error) {
+		c, err := vaultCredential(ctx, q, value)
+		if err != nil {
+			return "", fmt.Errorf("%s: auth=%s: %s: %w", me, opt.auth, name, err)
+		}
+		return c, nil
+	}
+
+	mount := opt.mount()
+
+	var loginPath string
+	data := map[string]any{}
+
+	switch opt.auth {
+	case "token":
+		if opt.authOption == "" {
+			return client, nil, nil // keep VAULT_TOKEN from environment
+		}
+		token, err := credential("token", opt.authOption)
+		if err != nil {
+			return nil, nil, err
+		}
+		client.SetToken(token)
+		return client, nil, nil
+
+	case "aws-role":
+		return vaultLoginAwsRole(ctx, client, opt.authOption, mount)
+
+	case "approle":
+		roleID, errRole := credential("role-id", opt.authOption)
+		if errRole != nil {
+			return nil, nil, errRole
+		}
+		secretID, errSecret := credential("secret-id", opt.options.Get("secret-id"))
+		if errSecret != nil {
+			return nil, nil, errSecret
+		}
+		loginPath = "auth/" + mount + "/login"
+		data["role_id"] = roleID
+		data["secret_id"] = secretID
+
+	case "kubernetes", "jwt", "oidc":
+		source := opt.options.Get("jwt")
+		if source == "" {
+			source = defaultKubernetesJWT
+		}
+		jwt, errJWT := credential("jwt", source)
+		if errJWT != nil {
+			return nil, nil, errJWT
+		}
+		loginPath = "auth/" + mount + "/login"
+		data["jwt"] = jwt
+		if opt.authOption != "" {
+			data["role"] = opt.authOption
+		}
+
+	case "userpass", "ldap":
+		username, errUser := credential("username", opt.authOption)
+		if errUser != nil {
+			return nil, nil, errUser
+		}
+		password, errPassword := credential("password", opt.options.Get("password"))
+		if errPassword != nil {
+			return nil, nil, errPassword
+		}
+		loginPath = "auth/" + mount + "/login/" + url.PathEscape(username)
+		data["password"] = password
+
+	case "cert":
+		loginPath = "auth/" + mount + "/login"
+		if opt.authOption != "" {
+			data["name"] = opt.authOption
+		}
+	}
+
+	secret, errLogin := client.Logical().WriteWithContext(ctx, loginPath, data)
+	if errLogin != nil {
+		return nil, nil, fmt.Errorf("%s: unable to login to %s auth method: %w", me, opt.auth, errLogin)
+	}
+	if secret == nil || secret.Auth == nil || secret.Auth.ClientToken == "" {
+		return nil, nil, fmt.Errorf("%s: no auth info was returned after %s login", me, opt.auth)
 	}
 
+	client.SetToken(secret.Auth.ClientToken)
+
+	return client, secret, nil
+}
+
+func vaultLoginAwsRole(ctx context.Context, client *vault.Client, role, mount string) (*vault.Client, *vault.Secret, error) {
 	awsAuth, err := auth.NewAWSAuth(
 		// if not provided, Vault will fall back on looking for
 		// a role with the IAM role name if you're using the iam auth type,
 		// or the EC2 instance's AMI id if using the ec2 auth type
 		auth.WithRole(role),
+		auth.WithMountPath(mount),
 	)
 	if err != nil {
-		return nil, fmt.Errorf("unable to initialize AWS auth method: %w", err)
+		return nil, nil, fmt.Errorf("unable to initialize AWS auth method: %w", err)
 	}
 
-	authInfo, err := client.Auth().Login(context.Background(), awsAuth)
+	authInfo, err := client.Auth().Login(ctx, awsAuth)
 	if err != nil {
-		return nil, fmt.Errorf("unable to login to AWS auth method: %w", err)
+		return nil, nil, fmt.Errorf("unable to login to AWS auth method: %w", err)
 	}
 	if authInfo == nil {
-		return nil, fmt.Errorf("no auth info was returned after login")
+		return nil, nil, fmt.Errorf("no auth info was returned after login")
 	}
 
-	return client, nil
+	return client, authInfo, nil
 }
 
-func vaultClient(vaultURL string) (*vault.Client, error) {
+func vaultClient(vaultURL string, opt vaultOptions) (*vault.Client, error) {
 	config := vault.DefaultConfig() // modify for more granular configuration
 	config.Address = vaultURL
+
+	ca := opt.options.Get("ca")
+	clientCert := opt.options.Get("client-cert")
+	clientKey := opt.options.Get("client-key")
+
+	if ca != "" || clientCert != "" {
+		tlsConfig := &vault.TLSConfig{
+			CACert:     ca,
+			ClientCert: clientCert,
+			ClientKey:  clientKey,
+		}
+		if err := config.ConfigureTLS(tlsConfig); err != nil {
+			return nil, fmt.Errorf("vaultClient: tls config error: url=%s: %w",
+				vaultURL, err)
+		}
+	}
+
 	client, err := vault.NewClient(config)
 	if err != nil {
 		return nil, fmt.Errorf("vaultClient: new client error: url=%s: %w",
 			vaultURL, err)
 	}
+
+	if ns := opt.options.Get("namespace"); ns != "" {
+		client.SetNamespace(ns)
+	}
+
 	return client, nil
 }
 
@@ -217,3 +637,68 @@ func parseSecretPath(path string) (string, string, string, error) {
 
 	return mountPath, secretPath, key, nil
 }
+
+// vaultMount describes the secrets engine mounted at a path.
+type vaultMount struct {
+	path    string // mount path, like secret/
+	kind    string // engine type, like kv or database
+	version string // kv version: 1 or 2
+}
+
+// vaultMount detects the secrets engine for the path, caching results in the Secret.
+func (q Query) vaultMount(ctx context.Context, client *vault.Client, opt vaultOptions, u, path string) (vaultMount, error) {
+	key := strings.Join([]string{u, opt.options.Get("namespace"), path}, "\x00")
+
+	if q.secret != nil {
+		if m, found := q.secret.vaultMounts.Load(key); found {
+			return m.(vaultMount), nil
+		}
+	}
+
+	m, err := vaultDetectMount(ctx, client, path)
+
+	var respErr *vault.ResponseError
+	if errors.As(err, &respErr) {
+		switch respErr.StatusCode {
+		case http.StatusNotFound, http.StatusMethodNotAllowed:
+			// server without mount detection: remember kv2 fallback
+			m, err = vaultMount{kind: "kv", version: "2"}, nil
+		}
+	}
+	if err != nil {
+		return m, err
+	}
+
+	if q.secret != nil {
+		q.secret.vaultMounts.Store(key, m)
+	}
+
+	return m, nil
+}
+
+// vaultDetectMount queries sys/internal/ui/mounts, which is available to
+// any token allowed to read the path, like vault cli does for kv commands.
+func vaultDetectMount(ctx context.Context, client *vault.Client, path string) (vaultMount, error) {
+	const me = "vaultDetectMount"
+
+	s, err := client.Logical().ReadWithContext(ctx, "sys/internal/ui/mounts/"+path)
+	if err != nil {
+		return vaultMount{}, err
+	}
+	if s == nil || s.Data == nil {
+		return vaultMount{}, fmt.Errorf("%s: no mount info for path: %s", me, path)
+	}
+
+	m := vaultMount{}
+	m.path, _ = s.Data["path"].(string)
+	m.kind, _ = s.Data["type"].(string)
+	if options, isMap := s.Data["options"].(map[string]any); isMap {
+		m.version, _ = options["version"].(string)
+	}
+
+	if m.kind == "" {
+		return m, fmt.Errorf("%s: missing mount type for path: %s", me, path)
+	}
+
+	return m, nil
+}
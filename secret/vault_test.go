@@ -1,6 +1,19 @@
 package secret
 
-import "testing"
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
 
 const (
 	expectError = true
@@ -73,3 +86,329 @@ func TestParseSecretPath(t *testing.T) {
 
 	}
 }
+
+type vaultOptionsTestCase struct {
+	name          string
+	reference     string
+	expectedError bool
+	auth          string
+	authOption    string
+	mount         string
+}
+
+var vaultOptionsTestTable = []vaultOptionsTestCase{
+	{"legacy token", "vault::token,dev-only-token,http,localhost,8200,secret/myapp1/mongodb:uri", expectOk, "token", "dev-only-token", "token"},
+	{"legacy default auth", "vault::,role1,http,localhost,8200,secret/myapp1/mongodb", expectOk, "aws-role", "role1", "aws"},
+	{"legacy approle", "vault::approle,@/run/role-id,https,vault,8200,secret/myapp1/mongodb,secret-id=@/run/secret-id,mount=ci/approle", expectOk, "approle", "@/run/role-id", "ci/approle"},
+	{"legacy approle missing secret-id", "vault::approle,role-id,https,vault,8200,secret/myapp1/mongodb", expectError, "", "", ""},
+	{"legacy kubernetes", "vault::kubernetes,myapp,https,vault,8200,secret/myapp1/mongodb", expectOk, "kubernetes", "myapp", "kubernetes"},
+	{"legacy userpass missing username", "vault::userpass,,https,vault,8200,secret/myapp1/mongodb,password=p", expectError, "", "", ""},
+	{"legacy cert missing key", "vault::cert,web,https,vault,8200,secret/myapp1/mongodb,client-cert=/tls/cert.pem", expectError, "", "", ""},
+	{"legacy unknown auth", "vault::github,x,https,vault,8200,secret/myapp1/mongodb", expectError, "", "", ""},
+	{"uri approle", "secret+vault://vault:8200/secret/myapp1/mongodb?auth=approle&role-id=r1&secret-id=s1", expectOk, "approle", "r1", "approle"},
+	{"uri ldap", "secret+vault://vault:8200/secret/myapp1/mongodb?auth=ldap&username=bob&password=p&mount=corp", expectOk, "ldap", "bob", "corp"},
+	{"uri jwt missing jwt", "secret+vault://vault:8200/secret/myapp1/mongodb?auth=jwt&role=r1", expectError, "", "", ""},
+	{"uri unknown option", "secret+vault://vault:8200/secret/myapp1/mongodb?auth=token&tokne=x", expectError, "", "", ""},
+	{"legacy engine and namespace", "vault::token,t,https,vault,8200,secret/myapp1/mongodb,engine=kv2,version=3,namespace=team-a", expectOk, "token", "t", "token"},
+	{"legacy bad engine", "vault::token,t,https,vault,8200,secret/myapp1/mongodb,engine=kv3", expectError, "", "", ""},
+	{"uri bad version", "secret+vault://vault:8200/secret/myapp1/mongodb?auth=token&token=t&version=latest", expectError, "", "", ""},
+}
+
+func TestParseVaultOptions(t *testing.T) {
+	for _, data := range vaultOptionsTestTable {
+		t.Run(data.name, func(t *testing.T) {
+			ref, errRef := ParseReference(data.reference)
+			if errRef != nil {
+				t.Fatalf("parse reference: %v", errRef)
+			}
+			opt, err := parseVaultOptions(Query{Region: ref.Region, Name: ref.Location, Reference: ref}, vaultReadOptionNames)
+			if data.expectedError {
+				if err == nil {
+					t.Errorf("expected error, got: %+v", opt)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if opt.auth != data.auth {
+				t.Errorf("auth: expected=%s got=%s", data.auth, opt.auth)
+			}
+			if opt.authOption != data.authOption {
+				t.Errorf("auth option: expected=%s got=%s", data.authOption, opt.authOption)
+			}
+			if mount := opt.mount(); mount != data.mount {
+				t.Errorf("mount: expected=%s got=%s", data.mount, mount)
+			}
+		})
+	}
+}
+
+// newFakeVault serves logins and KV v2 reads of secret/myapp1 with {"mongodb":"{\"uri\":\"abc\"}"}.
+// logins maps login path to expected request body.
+func newFakeVault(t *testing.T, logins map[string]map[string]any) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/v1/")
+
+		if expected, isLogin := logins[path]; isLogin {
+			var body map[string]any
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body) != len(expected) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			for k, v := range expected {
+				if body[k] != v {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+			}
+			json.NewEncoder(w).Encode(map[string]any{"auth": map[string]any{"client_token": "login-token"}})
+			return
+		}
+
+		if path == "secret/data/myapp1" {
+			if r.Header.Get("X-Vault-Token") != "login-token" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{
+				"data":     map[string]any{"mongodb": `{"uri":"abc"}`},
+				"metadata": map[string]any{"version": 1},
+			}})
+			return
+		}
+
+		w.WriteHeader(http.StatusNotFound)
+	}))
+}
+
+func TestVaultAuthMethods(t *testing.T) {
+
+	dir := t.TempDir()
+	secretIDFile := filepath.Join(dir, "secret-id")
+	if err := os.WriteFile(secretIDFile, []byte("s3cr3t\n"), 0o600); err != nil {
+		t.Fatalf("write secret-id: %v", err)
+	}
+	jwtFile := filepath.Join(dir, "token")
+	if err := os.WriteFile(jwtFile, []byte("k8s-jwt"), 0o600); err != nil {
+		t.Fatalf("write jwt: %v", err)
+	}
+
+	ts := newFakeVault(t, map[string]map[string]any{
+		"auth/approle/login":      {"role_id": "r1", "secret_id": "s3cr3t"},
+		"auth/kubernetes/login":   {"role": "myapp", "jwt": "k8s-jwt"},
+		"auth/oidc/login":         {"jwt": "oidc-jwt"},
+		"auth/corp/login/bob":     {"password": "pw"},
+		"auth/userpass/login/bob": {"password": "pw"},
+		"auth/approle-ci/login":   {"role_id": "r2", "secret_id": "s3cr3t"},
+	})
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+
+	secret := New(Options{AwsConfigSource: &AwsConfigSource{}, CacheTTLSeconds: -1})
+
+	// credentials from another store
+	secret.RegisterBackend("my-store", BackendFunc(func(_ context.Context, q Query) (string, error) {
+		return `{"password":"pw","jwt":"oidc-jwt"}`, nil
+	}))
+
+	base := "secret+vault://" + u.Host + "/secret/myapp1/mongodb?proto=http&field=uri&"
+
+	names := map[string]string{
+		"approle":    base + "auth=approle&role-id=r1&secret-id=@" + url.QueryEscape(secretIDFile),
+		"kubernetes": base + "auth=kubernetes&role=myapp&jwt=@" + url.QueryEscape(jwtFile),
+		"oidc":       base + "auth=oidc&jwt=" + url.QueryEscape("my-store:us-east-1:creds:jwt"),
+		"ldap":       base + "auth=ldap&mount=corp&username=bob&password=" + url.QueryEscape("my-store:us-east-1:creds:password"),
+		"legacy userpass": "vault||userpass,bob,http," + u.Hostname() + "," + u.Port() +
+			",secret/myapp1/mongodb,password=my-store:us-east-1:creds:password|uri",
+		"legacy approle mount": "vault::approle,r2,http," + u.Hostname() + "," + u.Port() +
+			",secret/myapp1/mongodb,secret-id=@" + secretIDFile + ",mount=approle-ci:uri",
+	}
+
+	for label, name := range names {
+		value, err := secret.RetrieveWithError(name)
+		if err != nil {
+			t.Errorf("%s: retrieve: %v", label, err)
+			continue
+		}
+		if value != "abc" {
+			t.Errorf("%s: expected=abc got=%s", label, value)
+		}
+	}
+
+	// wrong secret id
+	if _, err := secret.RetrieveWithError(base + "auth=approle&role-id=r1&secret-id=wrong"); err == nil {
+		t.Errorf("expected login error")
+	}
+}
+
+// fakeVaultEngines serves multiple secrets engines, as token dev-only-token.
+func fakeVaultEngines(t *testing.T, detection bool) *httptest.Server {
+	t.Helper()
+
+	mounts := map[string]map[string]any{
+		"secret/":   {"path": "secret/", "type": "kv", "options": map[string]any{"version": "2"}},
+		"kv1/":      {"path": "kv1/", "type": "kv", "options": map[string]any{"version": "1"}},
+		"kv/team/":  {"path": "kv/team/", "type": "kv", "options": map[string]any{"version": "2"}},
+		"database/": {"path": "database/", "type": "database"},
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "dev-only-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		ns := r.Header.Get("X-Vault-Namespace")
+		path := strings.TrimPrefix(r.URL.Path, "/v1/")
+
+		reply := func(data map[string]any) {
+			json.NewEncoder(w).Encode(map[string]any{"data": data})
+		}
+
+		if mountPath, isDetect := strings.CutPrefix(path, "sys/internal/ui/mounts/"); isDetect {
+			if !detection {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			for prefix, m := range mounts {
+				if strings.HasPrefix(mountPath+"/", prefix) {
+					reply(m)
+					return
+				}
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch {
+		case path == "secret/data/myapp1" && r.URL.Query().Get("version") == "1":
+			reply(map[string]any{"data": map[string]any{"mongodb": "v1"}})
+		case path == "secret/data/myapp1":
+			reply(map[string]any{"data": map[string]any{"mongodb": "v2"}})
+		case path == "kv1/myapp1":
+			reply(map[string]any{"mongodb": "kv1"})
+		case path == "kv/team/data/myapp1" && ns == "team-a":
+			reply(map[string]any{"data": map[string]any{"mongodb": "team"}})
+		case path == "database/creds/readonly":
+			json.NewEncoder(w).Encode(map[string]any{
+				"lease_id": "database/creds/readonly/abc", "lease_duration": 3600, "renewable": true,
+				"data": map[string]any{"username": "u1", "password": "p1"},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func TestVaultEngines(t *testing.T) {
+
+	ts := fakeVaultEngines(t, true)
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+
+	secret := New(Options{AwsConfigSource: &AwsConfigSource{}, CacheTTLSeconds: -1})
+
+	legacy := "vault::token,dev-only-token,http," + u.Hostname() + "," + u.Port() + ","
+	uri := "secret+vault://" + u.Host + "/"
+
+	cases := []struct {
+		name     string
+		expected string
+	}{
+		{legacy + "secret/myapp1/mongodb", "v2"},
+		{legacy + "secret/myapp1/mongodb,version=1", "v1"},
+		{legacy + "kv1/myapp1/mongodb", "kv1"},
+		{legacy + "kv1/myapp1/mongodb,engine=kv1", "kv1"},
+		{legacy + "kv/team/myapp1/mongodb,namespace=team-a", "team"},
+		{legacy + "database/creds/readonly:username", "u1"},
+		{uri + "database/creds/readonly?proto=http&auth=token&token=dev-only-token&engine=logical&field=password", "p1"},
+		{uri + "secret/myapp1/mongodb?proto=http&auth=token&token=dev-only-token&engine=kv2&version=1", "v1"},
+	}
+
+	for _, c := range cases {
+		value, err := secret.RetrieveWithError(c.name)
+		if err != nil {
+			t.Errorf("%s: retrieve: %v", c.name, err)
+			continue
+		}
+		if value != c.expected {
+			t.Errorf("%s: expected=%s got=%s", c.name, c.expected, value)
+		}
+	}
+
+	// version requires kv2
+	if _, err := secret.RetrieveWithError(legacy + "kv1/myapp1/mongodb,version=1"); !errors.Is(err, ErrMalformedReference) {
+		t.Errorf("expected ErrMalformedReference, got: %v", err)
+	}
+
+	// missing secret
+	if _, err := secret.RetrieveWithError(legacy + "secret/other/mongodb"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got: %v", err)
+	}
+}
+
+func TestVaultEnginesWithoutDetection(t *testing.T) {
+
+	ts := fakeVaultEngines(t, false)
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+
+	secret := New(Options{AwsConfigSource: &AwsConfigSource{}, CacheTTLSeconds: -1})
+
+	legacy := "vault::token,dev-only-token,http," + u.Hostname() + "," + u.Port() + ","
+
+	// falls back to kv2
+	if value, err := secret.RetrieveWithError(legacy + "secret/myapp1/mongodb"); err != nil || value != "v2" {
+		t.Errorf("kv2 fallback: value=%s err=%v", value, err)
+	}
+
+	// explicit engine
+	if value, err := secret.RetrieveWithError(legacy + "kv1/myapp1/mongodb,engine=kv1"); err != nil || value != "kv1" {
+		t.Errorf("kv1: value=%s err=%v", value, err)
+	}
+}
+
+func TestVaultEnginesTransientDetectionFailure(t *testing.T) {
+
+	t.Setenv("VAULT_MAX_RETRIES", "0")
+
+	ts := fakeVaultEngines(t, true)
+	defer ts.Close()
+
+	var failDetection atomic.Bool
+	failDetection.Store(true)
+
+	backend, _ := url.Parse(ts.URL)
+	proxy := httputil.NewSingleHostReverseProxy(backend)
+
+	front := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failDetection.Load() && strings.HasPrefix(r.URL.Path, "/v1/sys/internal/ui/mounts/") {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		proxy.ServeHTTP(w, r)
+	}))
+	defer front.Close()
+
+	u, _ := url.Parse(front.URL)
+
+	secret := New(Options{AwsConfigSource: &AwsConfigSource{}, CacheTTLSeconds: -1})
+
+	name := "vault::token,dev-only-token,http," + u.Hostname() + "," + u.Port() + ",kv1/myapp1/mongodb"
+
+	// rate limited detection: error, kv2 fallback not remembered
+	if value, err := secret.RetrieveWithError(name); err == nil {
+		t.Errorf("expected error on transient detection failure, got: %s", value)
+	}
+
+	failDetection.Store(false)
+
+	if value, err := secret.RetrieveWithError(name); err != nil || value != "kv1" {
+		t.Errorf("kv1 after detection recovered: value=%s err=%v", value, err)
+	}
+}
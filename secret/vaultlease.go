@@ -0,0 +1,212 @@
+package secret
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// LeaseHandler is invoked when the lease for a vault dynamic secret,
+// like database credentials, can no longer be renewed. err is nil when
+// the lease simply reached its maximum TTL. The next retrieval of ref
+// fetches fresh credentials.
+type LeaseHandler func(ref Reference, err error)
+
+// vaultLease holds a dynamic secret read from vault, shared by all
+// fields of the same reference, and keeps its lease renewed.
+type vaultLease struct {
+	mutex   sync.Mutex
+	client  *vault.Client // client that owns the lease
+	leaseID string
+	value   string
+	expire  time.Time
+	watcher *vault.LifetimeWatcher
+	valid   bool
+}
+
+// vaultLease returns the lease entry for the reference.
+func (s *Secret) vaultLease(ref Reference) *vaultLease {
+	key := ref.cacheKey()
+
+	s.vaultLeasesLock.Lock()
+	defer s.vaultLeasesLock.Unlock()
+
+	if s.vaultLeases == nil {
+		s.vaultLeases = map[string]*vaultLease{}
+	}
+	lease, found := s.vaultLeases[key]
+	if !found {
+		lease = &vaultLease{}
+		s.vaultLeases[key] = lease
+	}
+	return lease
+}
+
+// get returns the credentials held by the lease while it is valid,
+// otherwise reads the path again. Reading a dynamic secret path issues
+// new credentials, hence they are kept for the lease lifetime rather
+// than the cache TTL.
+func (vl *vaultLease) get(ctx context.Context, q Query, client *vault.Client, path string) (string, error) {
+	const me = "vaultLease.get"
+
+	vl.mutex.Lock()
+	defer vl.mutex.Unlock()
+
+	if vl.valid && time.Now().Before(vl.expire) {
+		return vl.value, nil
+	}
+
+	vl.stop()
+
+	s, value, err := vaultReadSecret(ctx, q, client, path)
+	if err != nil {
+		return "", err
+	}
+
+	if s.LeaseID == "" || s.LeaseDuration <= 0 {
+		return value, nil // not a dynamic secret
+	}
+
+	if q.Debug {
+		q.Printf("DEBUG %s: path=%s: new lease_id=%s lease_duration=%d renewable=%t",
+			me, path, s.LeaseID, s.LeaseDuration, s.Renewable)
+	}
+
+	vl.client = client
+	vl.leaseID = s.LeaseID
+	vl.value = value
+	vl.expire = time.Now().Add(time.Duration(s.LeaseDuration) * time.Second)
+	vl.valid = true
+
+	// the watcher renews renewable leases, and reports
+	// non-renewable leases shortly before they expire
+	watcher, errWatcher := client.NewLifetimeWatcher(&vault.LifetimeWatcherInput{Secret: s})
+	if errWatcher != nil {
+		q.Printf("%s: path=%s lease_id=%s: lease renewal disabled: %v", me, path, s.LeaseID, errWatcher)
+		return value, nil
+	}
+
+	vl.watcher = watcher
+
+	go watcher.Start()
+	go vl.watch(q, watcher)
+
+	return value, nil
+}
+
+// watch tracks lease renewals. When the lease can no longer be renewed,
+// the credentials are discarded from the lease and from the cache, then
+// Options.OnLeaseExpired is invoked.
+func (vl *vaultLease) watch(q Query, watcher *vault.LifetimeWatcher) {
+	const me = "vaultLease.watch"
+
+	for {
+		select {
+		case err := <-watcher.DoneCh():
+			vl.mutex.Lock()
+			current := vl.watcher == watcher
+			leaseID := vl.leaseID
+			if current {
+				vl.valid = false
+				vl.watcher = nil
+			}
+			vl.mutex.Unlock()
+
+			if !current {
+				return // stopped
+			}
+
+			q.Printf("%s: lease_id=%s: lease can no longer be renewed: %v", me, leaseID, err)
+
+			if s := q.secret; s != nil {
+				s.cache.Delete(q.Reference.cacheKey())
+				if s.options.OnLeaseExpired != nil {
+					s.options.OnLeaseExpired(q.Reference, err)
+				}
+			}
+			return
+
+		case renewal := <-watcher.RenewCh():
+			if renewal == nil || renewal.Secret == nil {
+				continue
+			}
+			ttl := time.Duration(renewal.Secret.LeaseDuration) * time.Second
+			if q.Debug {
+				q.Printf("DEBUG %s: lease_id=%s: lease renewed ttl=%v", me, renewal.Secret.LeaseID, ttl)
+			}
+			vl.mutex.Lock()
+			if vl.watcher == watcher && ttl > 0 {
+				vl.expire = renewal.RenewedAt.Add(ttl)
+			}
+			vl.mutex.Unlock()
+		}
+	}
+}
+
+// stop stops lease renewal. Caller must hold the mutex.
+func (vl *vaultLease) stop() {
+	if vl.watcher != nil {
+		vl.watcher.Stop()
+		vl.watcher = nil
+	}
+	vl.valid = false
+}
+
+// close stops lease renewal and revokes the lease, if still valid.
+func (vl *vaultLease) close(ctx context.Context) error {
+	vl.mutex.Lock()
+	defer vl.mutex.Unlock()
+
+	valid := vl.valid && time.Now().Before(vl.expire)
+
+	vl.stop()
+
+	if !valid || vl.client == nil {
+		return nil
+	}
+
+	leaseID := vl.leaseID
+	vl.leaseID = ""
+
+	if err := vl.client.Sys().RevokeWithContext(ctx, leaseID); err != nil {
+		return fmt.Errorf("revoke lease_id=%s: %w", leaseID, err)
+	}
+
+	return nil
+}
+
+// vaultLeasesHeld reports whether valid leases were obtained with the
+// client, hence revoking its token would revoke them as well.
+func (s *Secret) vaultLeasesHeld(client *vault.Client) bool {
+	s.vaultLeasesLock.Lock()
+	defer s.vaultLeasesLock.Unlock()
+
+	for _, lease := range s.vaultLeases {
+		lease.mutex.Lock()
+		held := lease.valid && lease.client == client && time.Now().Before(lease.expire)
+		lease.mutex.Unlock()
+		if held {
+			return true
+		}
+	}
+	return false
+}
+
+// closeVaultLeases stops renewal and revokes all leases.
+func (s *Secret) closeVaultLeases(ctx context.Context) []error {
+	s.vaultLeasesLock.Lock()
+	leases := s.vaultLeases
+	s.vaultLeases = nil
+	s.vaultLeasesLock.Unlock()
+
+	var errs []error
+	for _, lease := range leases {
+		if err := lease.close(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
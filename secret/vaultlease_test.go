@@ -0,0 +1,126 @@
+package secret
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeVaultLeases issues numbered database credentials under short
+// leases, renewed only up to maxRenewals times.
+type fakeVaultLeases struct {
+	mutex       sync.Mutex
+	reads       int
+	renewals    int
+	maxRenewals int
+	revoked     []string
+}
+
+func (f *fakeVaultLeases) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	switch strings.TrimPrefix(r.URL.Path, "/v1/") {
+	case "database/creds/app":
+		f.reads++
+		json.NewEncoder(w).Encode(map[string]any{
+			"lease_id":       fmt.Sprintf("database/creds/app/lease-%d", f.reads),
+			"lease_duration": 2,
+			"renewable":      true,
+			"data": map[string]any{
+				"username": fmt.Sprintf("user-%d", f.reads),
+				"password": fmt.Sprintf("pass-%d", f.reads),
+			},
+		})
+	case "sys/leases/renew":
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		if f.renewals >= f.maxRenewals {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"errors":["lease not found"]}`)
+			return
+		}
+		f.renewals++
+		json.NewEncoder(w).Encode(map[string]any{
+			"lease_id": body["lease_id"], "lease_duration": 2, "renewable": true,
+		})
+	case "sys/leases/revoke":
+		var body map[string]any
+		json.NewDecoder(r.Body).Decode(&body)
+		f.revoked = append(f.revoked, fmt.Sprint(body["lease_id"]))
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestVaultLease(t *testing.T) {
+
+	fake := &fakeVaultLeases{maxRenewals: 1}
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+
+	expired := make(chan Reference, 1)
+
+	secret := New(Options{
+		AwsConfigSource: &AwsConfigSource{},
+		CacheTTLSeconds: -1,
+		OnLeaseExpired: func(ref Reference, _ error) {
+			expired <- ref
+		},
+	})
+
+	base := "secret+vault://" + u.Host + "/database/creds/app?proto=http&auth=token&token=dev-only-token&engine=logical&field=%s"
+
+	retrieve := func(field string) string {
+		t.Helper()
+		value, err := secret.RetrieveWithError(fmt.Sprintf(base, field))
+		if err != nil {
+			t.Fatalf("retrieve %s: %v", field, err)
+		}
+		return value
+	}
+
+	// both fields from the same credentials, without cache
+	if user, pass := retrieve("username"), retrieve("password"); user != "user-1" || pass != "pass-1" {
+		t.Errorf("expected user-1/pass-1, got %s/%s", user, pass)
+	}
+
+	select {
+	case ref := <-expired:
+		if ref.Location != "database/creds/app" {
+			t.Errorf("unexpected expired reference: %+v", ref)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("lease expiration not reported")
+	}
+
+	fake.mutex.Lock()
+	reads, renewals := fake.reads, fake.renewals
+	fake.mutex.Unlock()
+
+	if reads != 1 || renewals != 1 {
+		t.Errorf("expected 1 read and 1 renewal, got reads=%d renewals=%d", reads, renewals)
+	}
+
+	// expired lease: fresh credentials
+	if user := retrieve("username"); user != "user-2" {
+		t.Errorf("expected user-2, got %s", user)
+	}
+
+	if err := secret.Close(); err != nil {
+		t.Errorf("close: %v", err)
+	}
+
+	if len(fake.revoked) != 1 || fake.revoked[0] != "database/creds/app/lease-2" {
+		t.Errorf("expected lease-2 revoked, got: %v", fake.revoked)
+	}
+}
@@ -0,0 +1,255 @@
+package secret
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+// vaultSession holds an authenticated vault client, shared by
+// queries with the same server and auth identity.
+type vaultSession struct {
+	mutex   sync.Mutex
+	client  *vault.Client
+	login   *vault.Secret // nil for token auth, whose token is not ours to renew or revoke
+	expire  time.Time     // zero for unknown expiration
+	watcher *vault.LifetimeWatcher
+	valid   bool
+}
+
+// vaultSessionKey identifies the server and auth identity.
+func vaultSessionKey(u string, opt vaultOptions) string {
+	fields := []string{u, opt.auth, opt.authOption}
+	for _, name := range vaultOptionNames {
+		fields = append(fields, opt.options.Get(name))
+	}
+	return strings.Join(fields, "\x00")
+}
+
+// vaultClient returns an authenticated vault client, cached by the Secret.
+// Queries built without a Secret login every time.
+func (q Query) vaultClient(ctx context.Context, opt vaultOptions, u string, rejected *vault.Client) (*vault.Client, error) {
+	if q.secret == nil {
+		client, _, err := vaultLogin(ctx, q, opt, u)
+		return client, err
+	}
+	return q.secret.vaultClient(ctx, q, opt, u, rejected)
+}
+
+// vaultClient returns a cached authenticated client, logging in if needed.
+// rejected is the client that got 403 from vault, or nil. The session logs
+// in again only if it still holds the rejected client and its token is
+// confirmed dead, hence concurrent queries rejected together share a single
+// new login. When the token is still valid, the 403 is a policy denial for
+// the path, and the same client is returned.
+func (s *Secret) vaultClient(ctx context.Context, q Query, opt vaultOptions, u string, rejected *vault.Client) (*vault.Client, error) {
+	key := vaultSessionKey(u, opt)
+
+	s.vaultSessionsLock.Lock()
+	if s.vaultSessions == nil {
+		s.vaultSessions = map[string]*vaultSession{}
+	}
+	session, found := s.vaultSessions[key]
+	if !found {
+		session = &vaultSession{}
+		s.vaultSessions[key] = session
+	}
+	s.vaultSessionsLock.Unlock()
+
+	return session.get(ctx, q, s, opt, u, rejected)
+}
+
+func (vs *vaultSession) get(ctx context.Context, q Query, s *Secret, opt vaultOptions, u string, rejected *vault.Client) (*vault.Client, error) {
+	const me = "vaultSession.get"
+
+	vs.mutex.Lock()
+	defer vs.mutex.Unlock()
+
+	relogin := rejected != nil && vs.client == rejected
+
+	if relogin && vs.valid && !vs.tokenDead(ctx) {
+		if q.Debug {
+			q.Printf("DEBUG %s: URL=%s auth=%s: token still valid, permission denied for path", me, u, opt.auth)
+		}
+		return vs.client, nil
+	}
+
+	if vs.valid && !relogin && (vs.expire.IsZero() || time.Now().Before(vs.expire)) {
+		return vs.client, nil
+	}
+
+	vs.stop()
+	vs.revoke(ctx, q, s)
+
+	client, login, errLogin := vaultLogin(ctx, q, opt, u)
+	if errLogin != nil {
+		return nil, errLogin
+	}
+
+	if q.Debug {
+		q.Printf("DEBUG %s: URL=%s auth=%s: new session relogin=%t", me, u, opt.auth, relogin)
+	}
+
+	vs.client = client
+	vs.login = login
+	vs.expire = time.Time{}
+	vs.valid = true
+
+	if login == nil || login.Auth == nil {
+		return client, nil
+	}
+
+	if ttl := login.Auth.LeaseDuration; ttl > 0 {
+		vs.expire = time.Now().Add(time.Duration(ttl) * time.Second)
+	}
+
+	if !login.Auth.Renewable {
+		return client, nil
+	}
+
+	watcher, errWatcher := client.NewLifetimeWatcher(&vault.LifetimeWatcherInput{Secret: login})
+	if errWatcher != nil {
+		q.Printf("%s: URL=%s auth=%s: token renewal disabled: %v", me, u, opt.auth, errWatcher)
+		return client, nil
+	}
+
+	vs.watcher = watcher
+
+	go watcher.Start()
+	go vs.watch(q, watcher)
+
+	return client, nil
+}
+
+// watch tracks token renewals. When the token can no longer be
+// renewed, the session is invalidated to login again on next query.
+func (vs *vaultSession) watch(q Query, watcher *vault.LifetimeWatcher) {
+	const me = "vaultSession.watch"
+
+	for {
+		select {
+		case err := <-watcher.DoneCh():
+			if err != nil {
+				q.Printf("%s: token renewal stopped: %v", me, err)
+			}
+			vs.mutex.Lock()
+			if vs.watcher == watcher {
+				vs.valid = false
+				vs.watcher = nil
+			}
+			vs.mutex.Unlock()
+			return
+
+		case renewal := <-watcher.RenewCh():
+			if renewal == nil || renewal.Secret == nil || renewal.Secret.Auth == nil {
+				continue
+			}
+			ttl := time.Duration(renewal.Secret.Auth.LeaseDuration) * time.Second
+			if q.Debug {
+				q.Printf("DEBUG %s: token renewed ttl=%v", me, ttl)
+			}
+			vs.mutex.Lock()
+			if vs.watcher == watcher && ttl > 0 {
+				vs.expire = renewal.RenewedAt.Add(ttl)
+			}
+			vs.mutex.Unlock()
+		}
+	}
+}
+
+// stop stops token renewal. Caller must hold the mutex.
+func (vs *vaultSession) stop() {
+	if vs.watcher != nil {
+		vs.watcher.Stop()
+		vs.watcher = nil
+	}
+	vs.valid = false
+}
+
+// tokenDead reports whether vault rejects the session token itself,
+// rather than denying access to a path. Caller must hold the mutex.
+func (vs *vaultSession) tokenDead(ctx context.Context) bool {
+	_, err := vs.client.Auth().Token().LookupSelfWithContext(ctx)
+	return isVaultForbidden(err)
+}
+
+// revoke revokes the token obtained by login, before the session is
+// replaced. It is best effort, since vault may have already rejected
+// the token. Tokens that own valid leases are kept, since revoking them
+// would revoke the leases. Caller must hold the mutex.
+func (vs *vaultSession) revoke(ctx context.Context, q Query, s *Secret) {
+	const me = "vaultSession.revoke"
+
+	if vs.login == nil || vs.client == nil {
+		return
+	}
+
+	if s.vaultLeasesHeld(vs.client) {
+		if q.Debug {
+			q.Printf("DEBUG %s: replaced token kept for its leases", me)
+		}
+		vs.login = nil
+		return
+	}
+
+	if err := vs.client.Auth().Token().RevokeSelfWithContext(ctx, ""); err != nil {
+		q.Printf("%s: revoke replaced token: %v", me, err)
+	}
+	vs.login = nil
+}
+
+// close stops token renewal and revokes the token obtained by login.
+func (vs *vaultSession) close(ctx context.Context) error {
+	vs.mutex.Lock()
+	defer vs.mutex.Unlock()
+
+	vs.stop()
+
+	if vs.login == nil || vs.client == nil {
+		return nil
+	}
+
+	err := vs.client.Auth().Token().RevokeSelfWithContext(ctx, "")
+	vs.login = nil
+	return err
+}
+
+// isVaultForbidden reports whether vault answered 403, either for an
+// invalid token or for a path denied by policy.
+func isVaultForbidden(err error) bool {
+	var respErr *vault.ResponseError
+	return errors.As(err, &respErr) && respErr.StatusCode == http.StatusForbidden
+}
+
+// Close releases resources held by the Secret: vault dynamic secret
+// leases are revoked, then vault tokens obtained by login are revoked,
+// and their renewal is stopped. Cached HTTP clients and OAuth2 tokens
+// are discarded.
+// The Secret remains usable, new queries login again.
+func (s *Secret) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	s.closeHTTP()
+
+	// revoke leases before the tokens that own them
+	errs := s.closeVaultLeases(ctx)
+
+	s.vaultSessionsLock.Lock()
+	sessions := s.vaultSessions
+	s.vaultSessions = nil
+	s.vaultSessionsLock.Unlock()
+
+	for _, session := range sessions {
+		if err := session.close(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,282 @@
+package secret
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeVaultSessions issues numbered tokens on approle login, serves
+// secret/myapp1 and database/creds/app for valid tokens, and denies
+// secret/denied by policy.
+type fakeVaultSessions struct {
+	mutex   sync.Mutex
+	logins  int
+	valid   map[string]bool
+	revoked []string
+	creds   int
+}
+
+func (f *fakeVaultSessions) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	token := r.Header.Get("X-Vault-Token")
+
+	switch strings.TrimPrefix(r.URL.Path, "/v1/") {
+	case "auth/approle/login":
+		f.logins++
+		token := fmt.Sprintf("token-%d", f.logins)
+		f.valid[token] = true
+		json.NewEncoder(w).Encode(map[string]any{"auth": map[string]any{
+			"client_token": token, "lease_duration": 3600, "renewable": true,
+		}})
+	case "auth/token/revoke-self":
+		f.revoked = append(f.revoked, token)
+		delete(f.valid, token)
+		w.WriteHeader(http.StatusNoContent)
+	case "auth/token/lookup-self":
+		if !f.valid[token] {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"errors":["permission denied"]}`)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"id": token}})
+	case "secret/data/denied":
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"errors":["permission denied"]}`)
+	case "database/creds/app":
+		if !f.valid[token] {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"errors":["permission denied"]}`)
+			return
+		}
+		f.creds++
+		json.NewEncoder(w).Encode(map[string]any{
+			"lease_id":       fmt.Sprintf("database/creds/app/lease-%d", f.creds),
+			"lease_duration": 3600,
+			"data":           map[string]any{"username": fmt.Sprintf("user-%d", f.creds)},
+		})
+	case "sys/leases/revoke":
+		w.WriteHeader(http.StatusNoContent)
+	case "secret/data/myapp1":
+		if !f.valid[token] {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"errors":["permission denied"]}`)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{
+			"data": map[string]any{"mongodb": `{"uri":"abc"}`, "redis": "redis://r"},
+		}})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestVaultSession(t *testing.T) {
+
+	fake := &fakeVaultSessions{valid: map[string]bool{}}
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+
+	secret := New(Options{AwsConfigSource: &AwsConfigSource{}, CacheTTLSeconds: -1})
+
+	base := "secret+vault://" + u.Host + "/secret/myapp1/%s?proto=http&auth=approle&role-id=r1&secret-id=s1"
+
+	for _, key := range []string{"mongodb", "redis", "mongodb"} {
+		if _, err := secret.RetrieveWithError(fmt.Sprintf(base, key)); err != nil {
+			t.Fatalf("retrieve %s: %v", key, err)
+		}
+	}
+
+	if fake.logins != 1 {
+		t.Errorf("expected session reuse with 1 login, got %d", fake.logins)
+	}
+
+	// token revoked by server: login again on 403
+	fake.mutex.Lock()
+	delete(fake.valid, "token-1")
+	fake.mutex.Unlock()
+
+	if _, err := secret.RetrieveWithError(fmt.Sprintf(base, "redis")); err != nil {
+		t.Fatalf("retrieve after revoke: %v", err)
+	}
+
+	if fake.logins != 2 {
+		t.Errorf("expected relogin, got %d logins", fake.logins)
+	}
+
+	// another identity: another session
+	if _, err := secret.RetrieveWithError(fmt.Sprintf(base, "redis") + "&mount=approle"); err != nil {
+		t.Fatalf("retrieve with mount: %v", err)
+	}
+
+	if fake.logins != 3 {
+		t.Errorf("expected new session, got %d logins", fake.logins)
+	}
+
+	if err := secret.Close(); err != nil {
+		t.Errorf("close: %v", err)
+	}
+
+	// token-1 revoked when replaced, token-2 and token-3 on close
+	slices.Sort(fake.revoked)
+	if !slices.Equal(fake.revoked, []string{"token-1", "token-2", "token-3"}) {
+		t.Errorf("expected 3 tokens revoked, got: %v", fake.revoked)
+	}
+}
+
+func TestVaultSessionConcurrentRelogin(t *testing.T) {
+
+	fake := &fakeVaultSessions{valid: map[string]bool{}}
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+
+	secret := New(Options{AwsConfigSource: &AwsConfigSource{}, CacheTTLSeconds: -1})
+
+	base := "secret+vault://" + u.Host + "/secret/myapp1/redis?proto=http&auth=approle&role-id=r1&secret-id=s1&engine=kv2&version=%d"
+
+	if _, err := secret.RetrieveWithError(fmt.Sprintf(base, 1)); err != nil {
+		t.Fatalf("retrieve: %v", err)
+	}
+
+	// token revoked by server: concurrent 403s share one login
+	fake.mutex.Lock()
+	delete(fake.valid, "token-1")
+	fake.mutex.Unlock()
+
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Go(func() {
+			// distinct versions, not coalesced into a single query
+			if _, err := secret.RetrieveWithError(fmt.Sprintf(base, i+2)); err != nil {
+				t.Errorf("retrieve: %v", err)
+			}
+		})
+	}
+	wg.Wait()
+
+	if fake.logins != 2 {
+		t.Errorf("expected 2 logins, got %d", fake.logins)
+	}
+}
+
+func TestVaultSessionTokenNotRevoked(t *testing.T) {
+
+	fake := &fakeVaultSessions{valid: map[string]bool{"dev-only-token": true}}
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+
+	secret := New(Options{AwsConfigSource: &AwsConfigSource{}, CacheTTLSeconds: -1})
+
+	if _, err := secret.RetrieveWithError("secret+vault://" + u.Host + "/secret/myapp1/redis?proto=http&auth=token&token=dev-only-token"); err != nil {
+		t.Fatalf("retrieve: %v", err)
+	}
+
+	if err := secret.Close(); err != nil {
+		t.Errorf("close: %v", err)
+	}
+
+	if len(fake.revoked) != 0 {
+		t.Errorf("static token must not be revoked, got: %v", fake.revoked)
+	}
+}
+
+func TestVaultSessionPolicyDenied(t *testing.T) {
+
+	fake := &fakeVaultSessions{valid: map[string]bool{}}
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+
+	secret := New(Options{AwsConfigSource: &AwsConfigSource{}, CacheTTLSeconds: -1})
+
+	auth := "?proto=http&auth=approle&role-id=r1&secret-id=s1"
+	creds := "secret+vault://" + u.Host + "/database/creds/app" + auth + "&engine=logical&field=username"
+	denied := "secret+vault://" + u.Host + "/secret/denied/redis" + auth + "&engine=kv2"
+
+	if _, err := secret.RetrieveWithError(creds); err != nil {
+		t.Fatalf("retrieve creds: %v", err)
+	}
+
+	// 403 for a path denied by policy: the token is still valid
+	for range 3 {
+		if _, err := secret.RetrieveWithError(denied); err == nil {
+			t.Fatalf("expected error for denied path")
+		}
+	}
+
+	value, err := secret.RetrieveWithError(creds)
+	if err != nil {
+		t.Fatalf("retrieve creds again: %v", err)
+	}
+	if value != "user-1" {
+		t.Errorf("expected lease kept with user-1, got: %s", value)
+	}
+
+	fake.mutex.Lock()
+	logins, revoked, reads := fake.logins, len(fake.revoked), fake.creds
+	fake.mutex.Unlock()
+
+	if logins != 1 {
+		t.Errorf("expected no relogin for denied path, got %d logins", logins)
+	}
+	if revoked != 0 {
+		t.Errorf("expected no token revoked, got: %v", fake.revoked)
+	}
+	if reads != 1 {
+		t.Errorf("expected lease reused, got %d reads", reads)
+	}
+}
+
+func TestVaultSessionKeepsLeaseToken(t *testing.T) {
+
+	fake := &fakeVaultSessions{valid: map[string]bool{}}
+	ts := httptest.NewServer(fake)
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+
+	secret := New(Options{AwsConfigSource: &AwsConfigSource{}, CacheTTLSeconds: -1})
+
+	auth := "?proto=http&auth=approle&role-id=r1&secret-id=s1"
+	creds := "secret+vault://" + u.Host + "/database/creds/app" + auth + "&engine=logical&field=username"
+	redis := "secret+vault://" + u.Host + "/secret/myapp1/redis" + auth + "&engine=kv2"
+
+	if _, err := secret.RetrieveWithError(creds); err != nil {
+		t.Fatalf("retrieve creds: %v", err)
+	}
+
+	// token rejected by server: relogin, but the old token owns a lease
+	fake.mutex.Lock()
+	delete(fake.valid, "token-1")
+	fake.mutex.Unlock()
+
+	if _, err := secret.RetrieveWithError(redis); err != nil {
+		t.Fatalf("retrieve after revoke: %v", err)
+	}
+
+	fake.mutex.Lock()
+	logins, revoked := fake.logins, slices.Clone(fake.revoked)
+	fake.mutex.Unlock()
+
+	if logins != 2 {
+		t.Errorf("expected relogin, got %d logins", logins)
+	}
+	if len(revoked) != 0 {
+		t.Errorf("token owning a lease must not be revoked, got: %v", revoked)
+	}
+}
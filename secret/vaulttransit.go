@@ -0,0 +1,102 @@
+package secret
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strings"
+
+	vault "github.com/hashicorp/vault/api"
+)
+
+/*
+export DB_URI='vault-transit||token,dev-only-token,https,vault,8200,transit/myapp,ciphertext=vault:v1:8SDd3WHDOjf7mq69CyCqYjBXAiQQAVZRkFM13ok481zoCmHnSeDX9vyf7w==|uri'
+
+export DB_URI='secret+vault-transit://vault:8200/transit/myapp?auth=token&token=dev-only-token&ciphertext=vault:v1:8SDd3WHDOjf7mq69CyCqYjBXAiQQAVZRkFM13ok481zoCmHnSeDX9vyf7w%3D%3D&field=uri'
+
+The path is the transit mount followed by the key name. The ciphertext
+contains ':', hence legacy syntax uses '|' as separator. In URI syntax,
+escape '+' in the ciphertext as %2B.
+
+Address and auth are the same as for the vault backend. Options:
+
+	ciphertext=vault:v1:...  transit ciphertext, or @/path/to/file, or secret reference
+	context=base64           key derivation context, for derived keys
+
+The ciphertext can be kept in a file committed to git, or in another store:
+
+export DB_URI='secret+vault-transit://vault:8200/transit/myapp?auth=approle&role-id=r1&secret-id=@/run/secrets/secret-id&ciphertext=aws-s3:us-east-1:bucket,config.enc&field=uri'
+*/
+func queryVaultTransit(ctx context.Context, q Query) (string, error) {
+	const me = "queryVaultTransit"
+
+	opt, errParse := parseVaultOptions(q, vaultTransitOptionNames)
+	if errParse != nil {
+		return "", fmt.Errorf("%w: %s: %w", ErrMalformedReference, me, errParse)
+	}
+
+	path := strings.Trim(strings.TrimSpace(opt.path), "/")
+
+	keyIndex := strings.LastIndexByte(path, '/')
+	if keyIndex < 1 || keyIndex == len(path)-1 {
+		return "", fmt.Errorf("%w: %s: expecting transit mount and key name as path: '%s'",
+			ErrMalformedReference, me, path)
+	}
+
+	ciphertext := strings.TrimSpace(opt.options.Get("ciphertext"))
+	if ciphertext == "" {
+		return "", fmt.Errorf("%w: %s: missing option 'ciphertext'", ErrMalformedReference, me)
+	}
+
+	if !transitCiphertext.MatchString(ciphertext) {
+		// ciphertext from file or from another store
+		var errCiphertext error
+		ciphertext, errCiphertext = vaultCredential(ctx, q, ciphertext)
+		if errCiphertext != nil {
+			return "", fmt.Errorf("%s: ciphertext: %w", me, errCiphertext)
+		}
+		ciphertext = strings.TrimSpace(ciphertext)
+	}
+
+	data := map[string]any{"ciphertext": ciphertext}
+	if c := opt.options.Get("context"); c != "" {
+		data["context"] = c
+	}
+
+	decryptPath := path[:keyIndex] + "/decrypt/" + path[keyIndex+1:]
+
+	if q.Debug {
+		q.Printf("DEBUG %s: path=%s", me, decryptPath)
+	}
+
+	return vaultQuery(ctx, q, opt, func(client *vault.Client, _ string) (string, error) {
+		s, err := client.Logical().WriteWithContext(ctx, decryptPath, data)
+		if err != nil {
+			return "", err
+		}
+		if s == nil || s.Data == nil {
+			return "", fmt.Errorf("%w: %s: no data from: %s", ErrNotFound, me, decryptPath)
+		}
+
+		plaintext, isStr := s.Data["plaintext"].(string)
+		if !isStr {
+			return "", fmt.Errorf("%w: %s: missing plaintext from: %s",
+				ErrFieldMissing, me, decryptPath)
+		}
+
+		decoded, errDecode := base64.StdEncoding.DecodeString(plaintext)
+		if errDecode != nil {
+			return "", fmt.Errorf("%s: decode base64 plaintext from: %s: %w",
+				me, decryptPath, errDecode)
+		}
+
+		return string(decoded), nil
+	})
+}
+
+// vaultTransitOptionNames lists named options for transit decryption.
+var vaultTransitOptionNames = []string{"ciphertext", "context"}
+
+// transitCiphertext matches ciphertext given literally: vault:v1:...
+var transitCiphertext = regexp.MustCompile(`^vault:v\d+:`)
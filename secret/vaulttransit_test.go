@@ -0,0 +1,105 @@
+package secret
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newFakeVaultTransit decrypts ciphertext "vault:v1:" + base64(plaintext)
+// with key myapp under mounts transit and team/transit.
+func newFakeVaultTransit(t *testing.T) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.TrimPrefix(r.URL.Path, "/v1/")
+
+		if r.Method != http.MethodPut && r.Method != http.MethodPost ||
+			(path != "transit/decrypt/myapp" && path != "team/transit/decrypt/myapp") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if r.Header.Get("X-Vault-Token") != "dev-only-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		var body map[string]string
+		json.NewDecoder(r.Body).Decode(&body)
+
+		plaintext, found := strings.CutPrefix(body["ciphertext"], "vault:v1:")
+		if !found || body["context"] != "" && body["context"] != "Y3R4" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]any{"errors": []string{"invalid ciphertext"}})
+			return
+		}
+
+		json.NewEncoder(w).Encode(map[string]any{"data": map[string]any{"plaintext": plaintext}})
+	}))
+}
+
+func TestVaultTransit(t *testing.T) {
+
+	ts := newFakeVaultTransit(t)
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	host, port, _ := strings.Cut(u.Host, ":")
+
+	ciphertext := "vault:v1:" + base64.StdEncoding.EncodeToString([]byte(`{"uri":"mongodb://db"}`))
+
+	file := filepath.Join(t.TempDir(), "config.enc")
+	if err := os.WriteFile(file, []byte(ciphertext+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	legacy := "vault-transit||token,dev-only-token,http," + host + "," + port + ","
+	base := "secret+vault-transit://" + u.Host + "/"
+	escaped := url.QueryEscape(ciphertext)
+
+	table := []struct {
+		name          string
+		ref           string
+		expectedError bool
+		expected      string
+	}{
+		{"legacy", legacy + "transit/myapp,ciphertext=" + ciphertext + "|uri", expectOk, "mongodb://db"},
+		{"legacy whole plaintext", legacy + "transit/myapp,ciphertext=" + ciphertext, expectOk, `{"uri":"mongodb://db"}`},
+		{"legacy context", legacy + "transit/myapp,ciphertext=" + ciphertext + ",context=Y3R4|uri", expectOk, "mongodb://db"},
+		{"legacy file", legacy + "transit/myapp,ciphertext=@" + file + "|uri", expectOk, "mongodb://db"},
+		{"uri", base + "transit/myapp?proto=http&auth=token&token=dev-only-token&ciphertext=" + escaped + "&field=uri", expectOk, "mongodb://db"},
+		{"uri nested mount", base + "team/transit/myapp?proto=http&auth=token&token=dev-only-token&ciphertext=" + escaped + "&field=uri", expectOk, "mongodb://db"},
+		{"bad ciphertext", legacy + "transit/myapp,ciphertext=vault:v1:bad|uri", expectError, ""},
+		{"missing ciphertext", legacy + "transit/myapp|uri", expectError, ""},
+		{"missing key", legacy + "transit,ciphertext=" + ciphertext + "|uri", expectError, ""},
+		{"unknown key", legacy + "transit/other,ciphertext=" + ciphertext + "|uri", expectError, ""},
+		{"wrong token", base + "transit/myapp?proto=http&auth=token&token=wrong&ciphertext=" + escaped, expectError, ""},
+		{"engine option", legacy + "transit/myapp,ciphertext=" + ciphertext + ",engine=kv2|uri", expectError, ""},
+	}
+
+	secret := New(Options{AwsConfigSource: &AwsConfigSource{}, Strict: true})
+
+	for _, data := range table {
+		t.Run(data.name, func(t *testing.T) {
+			value, err := secret.RetrieveWithError(data.ref)
+			if data.expectedError {
+				if err == nil {
+					t.Errorf("expected error, got value: %s", value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if value != data.expected {
+				t.Errorf("expected '%s', got '%s'", data.expected, value)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package secret
+
+import (
+	"context"
+	"time"
+)
+
+// Watch refreshes a secret in background and invokes onChange whenever
+// the value changes in the store, for instance after a rotation in
+// AWS Secrets Manager or Vault.
+//
+// The initial value is taken with RetrieveWithError, hence it is the
+// same value a previous Retrieve call would have returned. Then the secret
+// is fetched from the store every interval, bypassing the cache.
+// The refreshed value is saved into the cache, so that Retrieve also
+// sees the new value.
+//
+// If the initial retrieval fails, the first successful refresh
+// invokes onChange with an empty oldValue. Refresh errors are logged
+// and the previous value is kept.
+//
+// Call the returned stop function to stop watching. It is safe
+// to call stop multiple times, including from onChange.
+//
+// name: aws-secretsmanager:region:name:json_field
+func (s *Secret) Watch(name string, interval time.Duration, onChange func(oldValue, newValue string)) (stop func()) {
+	const me = "Secret.Watch"
+
+	if interval <= 0 {
+		panic("Watch: non-positive interval")
+	}
+
+	current, errInitial := s.RetrieveWithError(name)
+	if errInitial != nil {
+		s.options.Printf("%s: initial retrieve error: name='%s': %v",
+			me, name, errInitial)
+		current = ""
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			value, err := s.refresh(ctx, name)
+			if err != nil {
+				if ctx.Err() == nil {
+					s.options.Printf("%s: refresh error: name='%s': %v",
+						me, name, err)
+				}
+				continue
+			}
+
+			if value == current || ctx.Err() != nil {
+				continue
+			}
+
+			if s.options.Debug {
+				s.options.Printf("%s: secret changed: name='%s'", me, name)
+			}
+
+			old := current
+			current = value
+			onChange(old, value)
+		}
+	}()
+
+	return cancel
+}
+
+// refresh fetches a secret from the store, bypassing the cache.
+func (s *Secret) refresh(ctx context.Context, name string) (string, error) {
+	return s.query(ctx, name, true)
+}
@@ -0,0 +1,57 @@
+package secret
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+
+	var lock sync.Mutex
+	stored := `{"password":"v1"}`
+
+	secret := New(Options{AwsConfigSource: &AwsConfigSource{}})
+
+	secret.RegisterBackend("rotating-store", BackendFunc(func(_ context.Context, _ Query) (string, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		return stored, nil
+	}))
+
+	const name = "rotating-store::db:password"
+
+	if value := secret.Retrieve(name); value != "v1" {
+		t.Fatalf("initial value: expected=v1 got=%s", value)
+	}
+
+	type change struct{ oldValue, newValue string }
+	changes := make(chan change, 1)
+
+	stop := secret.Watch(name, 10*time.Millisecond, func(oldValue, newValue string) {
+		changes <- change{oldValue, newValue}
+	})
+	defer stop()
+
+	lock.Lock()
+	stored = `{"password":"v2"}`
+	lock.Unlock()
+
+	select {
+	case c := <-changes:
+		if c.oldValue != "v1" || c.newValue != "v2" {
+			t.Errorf("change error: expected=v1->v2 got=%s->%s", c.oldValue, c.newValue)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for change")
+	}
+
+	// refreshed value must have been saved to cache
+	if value := secret.Retrieve(name); value != "v2" {
+		t.Errorf("cached value: expected=v2 got=%s", value)
+	}
+
+	stop()
+	stop() // must be safe
+}